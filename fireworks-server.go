@@ -11,6 +11,7 @@ import (
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rschoen/fireworks-server/lib"
@@ -37,12 +38,17 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if command == "stats" {
-
-		statsLog := s.db.CreateStatsMessage()
-
-		json, err := lib.EncodeStatsMessage(statsLog)
+		json := s.cachedStats()
+		if json == "" || !s.cacheStats {
+			json = s.refreshStats()
+		}
+		fmt.Fprint(w, json)
+		return
+	}
+	if command == "variants" {
+		json, err := lib.EncodeVariants(lib.GetVariants())
 		if err != "" {
-			log.Printf("Failed to encode stats log. Error: %s\n", err)
+			log.Printf("Failed to encode variants. Error: %s\n", err)
 			return
 		}
 		fmt.Fprint(w, json)
@@ -75,6 +81,127 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if command == "jobs" {
+		if !s.admins[m.Player] {
+			log.Printf("Non-admin player '%s' asked for job status.", m.Player)
+			fmt.Fprint(w, jsonError("Only admins can see this."))
+			return
+		}
+		json, err := lib.EncodeJobs(s.scheduler.Status())
+		if err != "" {
+			log.Printf("Failed to encode job status. Error: %s\n", err)
+			fmt.Fprint(w, jsonError("Could not transmit job status to client."))
+			return
+		}
+		fmt.Fprint(w, json)
+		return
+	}
+
+	// everything from here on reads or changes games
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if command == "tournament-create" || command == "tournament-register" || command == "tournament-round" {
+		if !s.admins[m.Player] {
+			log.Printf("Non-admin player '%s' tried to run a tournament.", m.Player)
+			fmt.Fprint(w, jsonError("Only admins can run tournaments."))
+			return
+		}
+	}
+
+	if command == "tournament-create" {
+		var tournamentError string
+		m.Tournament, tournamentError = s.db.CreateTournament(sanitizeAndTrim(m.Name, lib.MaxGameNameLength, false), m.GameMode, m.NumPlayers, m.Rounds, m.Options)
+		if tournamentError != "" {
+			log.Printf("Failed to create tournament '%s'. Error: %s\n", m.Name, tournamentError)
+			fmt.Fprint(w, jsonError("Could not create tournament."))
+			return
+		}
+		log.Printf("Created tournament '%s'\n", m.Tournament)
+		command = "tournament"
+	}
+
+	if command == "tournament-register" {
+		registerError := s.db.RegisterTeam(m.Tournament, sanitizeAndTrim(m.Team, lib.MaxGameNameLength, false), m.TeamPlayers)
+		if registerError != "" {
+			log.Printf("Failed to register team '%s' in tournament '%s'. Error: %s\n", m.Team, m.Tournament, registerError)
+			fmt.Fprint(w, jsonError("Could not register team."))
+			return
+		}
+		log.Printf("Registered team '%s' in tournament '%s'\n", m.Team, m.Tournament)
+		command = "tournament"
+	}
+
+	if command == "tournament-round" {
+		roundError := s.startTournamentRound(m.Tournament)
+		if roundError != "" {
+			log.Printf("Failed to start a round of tournament '%s'. Error: %s\n", m.Tournament, roundError)
+			fmt.Fprint(w, jsonError("Could not start the next round."))
+			return
+		}
+		command = "tournament"
+	}
+
+	if command == "tournament" {
+		tournament, tournamentError := s.db.GetTournament(m.Tournament)
+		if tournamentError != "" {
+			log.Printf("Failed to look up tournament '%s'. Error: %s\n", m.Tournament, tournamentError)
+			fmt.Fprint(w, jsonError("The tournament you're looking for doesn't exist."))
+			return
+		}
+		json, err := lib.EncodeTournament(tournament)
+		if err != "" {
+			log.Printf("Failed to encode tournament '%s'. Error: %s\n", m.Tournament, err)
+			fmt.Fprint(w, jsonError("Could not transmit tournament to client."))
+			return
+		}
+		fmt.Fprint(w, json)
+		return
+	}
+
+	if command == "ratings" {
+		json, err := lib.EncodeRatings(s.db.GetRatingLeaderboard(m.GameMode))
+		if err != "" {
+			log.Printf("Failed to encode ratings. Error: %s\n", err)
+			fmt.Fprint(w, jsonError("Could not transmit ratings to client."))
+			return
+		}
+		fmt.Fprint(w, json)
+		return
+	}
+
+	if command == "series" {
+		series, seriesError := s.db.GetSeries(m.Series)
+		if seriesError != "" {
+			log.Printf("Failed to look up series '%s'. Error: %s\n", m.Series, seriesError)
+			fmt.Fprint(w, jsonError("The series you're looking for doesn't exist."))
+			return
+		}
+		json, err := lib.EncodeSeries(series)
+		if err != "" {
+			log.Printf("Failed to encode series '%s'. Error: %s\n", m.Series, err)
+			fmt.Fprint(w, jsonError("Could not transmit series to client."))
+			return
+		}
+		fmt.Fprint(w, json)
+		return
+	}
+
+	if command == "challenge" {
+		date := m.ChallengeDate
+		if date == "" {
+			date = lib.ChallengeDate(time.Now())
+		}
+		json, err := lib.EncodeChallengeBoard(s.db.GetChallengeBoard(date, m.GameMode, m.NumPlayers))
+		if err != "" {
+			log.Printf("Failed to encode challenge leaderboard. Error: %s\n", err)
+			fmt.Fprint(w, jsonError("Could not transmit leaderboard to client."))
+			return
+		}
+		fmt.Fprint(w, json)
+		return
+	}
+
 	if command == "list" {
 		list := lib.GamesList{}
 		playersGames := s.db.GetGamesPlayerIsIn(m.Player)
@@ -127,14 +254,37 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Creating a new game.")
 		selectedGame = new(lib.Game)
 		selectedGame.Name = sanitizeAndTrim(m.Game, lib.MaxGameNameLength, false)
-		selectedGame.ID = selectedGame.Name + "-" + strconv.FormatInt(time.Now().Unix(), 10)
+		var idError string
+		selectedGame.ID, idError = s.db.NewGameID()
+		if idError != "" {
+			log.Printf("Failed to pick an ID for game '%s'. Error: %s\n", m.Game, idError)
+			fmt.Fprint(w, jsonError("Could not create game."))
+			return
+		}
+		selectedGame.Host = m.Player
 
-		var initializationError = selectedGame.Initialize(m.Public, m.IgnoreTime, m.SighButton, m.GameMode)
+		var initializationError = selectedGame.Initialize(m.Public, m.IgnoreTime, m.SighButton, m.GameMode, m.Options)
 		if initializationError != "" {
 			log.Printf("Failed to initialize game '%s'. Error: %s\n", m.Game, initializationError)
 			fmt.Fprint(w, jsonError("Could not initialize game."))
 			return
 		}
+		if m.Challenge {
+			selectedGame.Challenge = lib.ChallengeDate(time.Now())
+		}
+		if m.SeriesLength > 0 || m.OpenSeries {
+			length := m.SeriesLength
+			if m.OpenSeries {
+				length = 0
+			}
+			var seriesError string
+			selectedGame.Series, seriesError = s.db.CreateSeries(selectedGame.Name, m.Player, length)
+			if seriesError != "" {
+				log.Printf("Failed to create series for game '%s'. Error: %s\n", m.Game, seriesError)
+				fmt.Fprint(w, jsonError("Could not create series."))
+				return
+			}
+		}
 		s.db.CreateGame(*selectedGame)
 		s.games[selectedGame.ID] = selectedGame
 		m.Game = selectedGame.ID
@@ -143,14 +293,33 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 		command = "join"
 	}
 
+	if _, ok := s.games[m.Game]; !ok {
+		// links shared before game IDs were made opaque
+		m.Game = s.db.ResolveGameID(m.Game)
+	}
 	if _, ok := s.games[m.Game]; ok {
 		selectedGame = s.games[m.Game]
+	} else if (command == "spectate" || command == "status" || command == "chat-history" || command == "rematch") && s.db.GameIDExists(m.Game) {
+		// finished games aren't kept in memory, but can still be watched or
+		// rematched from a copy loaded just for this request
+		selectedGame = s.db.LookupGameById(m.Game)
 	} else {
 		log.Printf("Attempting to make a move on a nonexistent game '%s'\n", m.Game)
 		fmt.Fprint(w, jsonError("The game you're attempting to play no longer exists."))
 		return
 	}
 
+	s.enforceTimeLimits(selectedGame)
+
+	if command == "join" || command == "leave" || command == "kick" || command == "seat" {
+		// tournament teams play as registered, and nobody else gets to see their deal
+		if (command != "join" || selectedGame.GetPlayerByGoogleID(m.Player) == nil) && s.db.IsTournamentGame(selectedGame.ID) {
+			log.Printf("Attempting to change the players of tournament game '%s'\n", m.Game)
+			fmt.Fprint(w, jsonError("Tournament teams can't be changed."))
+			return
+		}
+	}
+
 	if command == "join" {
 		log.Printf("Joining a game.")
 		player := selectedGame.GetPlayerByGoogleID(m.Player)
@@ -162,6 +331,11 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 				fmt.Fprint(w, jsonError("This game is now full."))
 				return
 			}
+			if !selectedGame.CanJoin(m.Player, m.InviteCode) {
+				log.Printf("Attempting to join private game '%s' without a valid invite code\n", m.Game)
+				fmt.Fprint(w, jsonError("You need an invite to join this game."))
+				return
+			}
 			playerName := sanitizeAndTrim(authResponse.GetGivenName(), lib.MaxPlayerNameLength, true)
 			addError := selectedGame.AddPlayer(m.Player, playerName)
 			s.db.CreatePlayerIfNotExists(m.Player, playerName)
@@ -186,11 +360,119 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	player := selectedGame.GetPlayerByGoogleID(m.Player)
 
 	if player == nil {
-		log.Printf("Attempting to make a move with nonexistent player '%s'\n", m.Player)
-		fmt.Fprint(w, jsonError("You're not a member of this game."))
+		s.handleSpectator(w, command, m, selectedGame)
+		return
+	}
+
+	if command == "leave" {
+		log.Printf("Leaving a game.")
+		var leaveError = selectedGame.RemovePlayer(m.Player)
+		if leaveError != "" {
+			log.Printf("Failed to remove player '%s' from game '%s'. Error: %s\n", m.Player, m.Game, leaveError)
+			fmt.Fprint(w, jsonError("Could not leave game."))
+			return
+		}
+		if len(selectedGame.Players) == 0 {
+			s.db.DeleteGame(selectedGame.ID)
+			delete(s.games, selectedGame.ID)
+		} else {
+			s.db.RemovePlayer(m.Player, selectedGame)
+		}
+		log.Printf("Removed player '%s' from game '%s'\n", m.Player, m.Game)
 		return
 	}
 
+	if command == "kick" || command == "seat" || command == "regenerate-invite" || command == "revoke-invite" {
+		if selectedGame.Host != m.Player {
+			log.Printf("Player '%s' is not the host of game '%s'\n", m.Player, m.Game)
+			fmt.Fprint(w, jsonError("Only the host can do that."))
+			return
+		}
+	}
+
+	if command == "kick" {
+		log.Printf("Kicking a player from a game.")
+		if m.TargetPlayer == m.Player {
+			fmt.Fprint(w, jsonError("Leave the game instead of kicking yourself."))
+			return
+		}
+		var kickError = selectedGame.RemovePlayer(m.TargetPlayer)
+		if kickError != "" {
+			log.Printf("Failed to kick player '%s' from game '%s'. Error: %s\n", m.TargetPlayer, m.Game, kickError)
+			fmt.Fprint(w, jsonError("Could not remove player."))
+			return
+		}
+		s.db.RemovePlayer(m.TargetPlayer, selectedGame)
+		log.Printf("Kicked player '%s' from game '%s'\n", m.TargetPlayer, m.Game)
+	}
+
+	if command == "seat" {
+		log.Printf("Changing seats in a game.")
+		var seatError = selectedGame.MovePlayer(m.TargetPlayer, m.Seat)
+		if seatError != "" {
+			log.Printf("Failed to move player '%s' in game '%s'. Error: %s\n", m.TargetPlayer, m.Game, seatError)
+			fmt.Fprint(w, jsonError("Could not change seats."))
+			return
+		}
+		s.db.SeatPlayers(selectedGame)
+		log.Printf("Moved player '%s' to seat %d in game '%s'\n", m.TargetPlayer, m.Seat, m.Game)
+	}
+
+	if command == "regenerate-invite" || command == "revoke-invite" {
+		log.Printf("Changing a game's invite code.")
+		if selectedGame.Public {
+			fmt.Fprint(w, jsonError("Public games don't need an invite."))
+			return
+		}
+		if command == "revoke-invite" {
+			selectedGame.RevokeInviteCode()
+		} else if inviteError := selectedGame.RegenerateInviteCode(); inviteError != "" {
+			log.Printf("Failed to regenerate invite code for game '%s'. Error: %s\n", m.Game, inviteError)
+			fmt.Fprint(w, jsonError("Could not create an invite."))
+			return
+		}
+		s.db.SaveInviteCode(selectedGame)
+		log.Printf("Changed invite code for game '%s'\n", m.Game)
+	}
+
+	if command == "rematch" {
+		log.Printf("Rematching a game.")
+		if selectedGame.NextGame == "" {
+			rematch, rematchError := selectedGame.Rematch(m.Rotate)
+			if rematchError == "" && rematch.Series != "" {
+				// once a series has all its games, rematches are just games
+				if series, seriesError := s.db.GetSeries(rematch.Series); seriesError != "" || series.Complete {
+					rematch.Series = ""
+				}
+			}
+			if rematchError == "" {
+				rematch.ID, rematchError = s.db.NewGameID()
+			}
+			if rematchError != "" {
+				log.Printf("Failed to rematch game '%s'. Error: %s\n", m.Game, rematchError)
+				fmt.Fprint(w, jsonError("Could not start a rematch."))
+				return
+			}
+			s.db.CreateGameWithPlayers(rematch)
+			s.games[rematch.ID] = rematch
+			selectedGame.NextGame = rematch.ID
+			s.db.SaveNextGame(selectedGame)
+			log.Printf("Created rematch '%s' of game '%s'\n", rematch.ID, m.Game)
+		}
+		// whoever asks after the first player just gets the rematch that was made
+		rematch, ok := s.games[selectedGame.NextGame]
+		if !ok && s.db.GameIDExists(selectedGame.NextGame) {
+			rematch, ok = s.db.LookupGameById(selectedGame.NextGame), true
+		}
+		if !ok {
+			log.Printf("Rematch '%s' of game '%s' no longer exists\n", selectedGame.NextGame, m.Game)
+			fmt.Fprint(w, jsonError("The rematch you're looking for no longer exists."))
+			return
+		}
+		selectedGame = rematch
+		m.Game = selectedGame.ID
+	}
+
 	if command == "start" {
 		log.Printf("Starting a game.")
 		if selectedGame.State != lib.StateNotStarted {
@@ -199,6 +481,14 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("Gonna start game %s with table %+v", selectedGame.ID, selectedGame.Table)
+		if selectedGame.Challenge != "" {
+			if played := s.db.ChallengeAlreadyPlayed(selectedGame.Challenge, selectedGame.Mode, selectedGame.Players); played != "" {
+				log.Printf("Player '%s' has already played the challenge deal for game '%s'\n", played, m.Game)
+				fmt.Fprint(w, jsonError("Someone in this game has already played this challenge deal."))
+				return
+			}
+			selectedGame.Table.Seed = s.db.ChallengeSeed(selectedGame.Challenge, selectedGame.Mode, len(selectedGame.Players))
+		}
 		var startError = selectedGame.Start()
 		if startError != "" {
 			log.Printf("Failed to start game '%s'. Error: %s\n", m.Game, startError)
@@ -220,6 +510,36 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Processed announcement by player '%s' in game '%s'\n", m.Player, m.Game)
 	}
 
+	if command == "propose-end" || command == "vote-end" {
+		log.Printf("Voting on ending a game.")
+		inFavor := m.Vote || command == "propose-end"
+		var voteError = selectedGame.VoteToEnd(m.Player, inFavor)
+		if voteError != "" {
+			log.Printf("Failed to record vote for game '%s'. Error: %s\n", m.Game, voteError)
+			fmt.Fprint(w, jsonError("Could not record vote."))
+			return
+		}
+		s.db.SaveGameToDatabase(selectedGame)
+		log.Printf("Recorded vote by player '%s' in game '%s'\n", m.Player, m.Game)
+	}
+
+	if command == "chat" {
+		log.Printf("Sending a chat message.")
+		message, chatError := selectedGame.NewChatMessage(m.Player, m.Text)
+		if chatError != "" {
+			log.Printf("Failed to send chat message in game '%s'. Error: %s\n", m.Game, chatError)
+			fmt.Fprint(w, jsonError("Could not send message."))
+			return
+		}
+		s.db.AddChatMessage(selectedGame.ID, message)
+		log.Printf("Sent chat message by player '%s' in game '%s'\n", m.Player, m.Game)
+	}
+
+	if command == "chat-history" {
+		s.writeChatHistory(w, m, selectedGame)
+		return
+	}
+
 	if command == "move" {
 
 		log.Printf("Making a move by player %s.", player.Name)
@@ -250,13 +570,16 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if command == "status" {
-		if m.LastTurn == selectedGame.Table.Turn && m.UpdateTime == selectedGame.LastUpdateTime {
+		if m.LastTurn == selectedGame.Table.Turn && m.UpdateTime == selectedGame.LastUpdateTime && m.ChatTime == selectedGame.LastChatTime {
 			fmt.Fprint(w, "")
 			return
 		}
 	}
 
-	encodedGame, err := lib.EncodeGame(selectedGame.CreateState(m.Player))
+	state := selectedGame.CreateState(m.Player, m.Empathy)
+	state.Localize(m.Language)
+	state.Chat = s.db.GetChatMessages(selectedGame.ID, 0, lib.ChatPageSize)
+	encodedGame, err := lib.EncodeGame(state)
 	if err != "" {
 		log.Printf("Failed to encode game '%s'. Error: %s\n", m.Game, err)
 		fmt.Fprint(w, jsonError("Could not transmit game state to client."))
@@ -265,6 +588,170 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, encodedGame)
 }
 
+// handleSpectator answers requests from someone who isn't playing the game,
+// who can only start, change or stop watching it and fetch its state.
+func (s *Server) handleSpectator(w http.ResponseWriter, command string, m lib.Message, game *lib.Game) {
+	if command == "spectate" {
+		log.Printf("Spectating a game.")
+		var spectateError = game.Spectate(m.Player, m.Seat)
+		if spectateError != "" {
+			log.Printf("Failed to add spectator '%s' to game '%s'. Error: %s\n", m.Player, m.Game, spectateError)
+			fmt.Fprint(w, jsonError("You can't watch this game."))
+			return
+		}
+		s.db.AddSpectator(m.Player, game.ID, m.Seat)
+		log.Printf("Added spectator '%s' to game '%s'\n", m.Player, m.Game)
+	}
+
+	if command == "stop-spectating" {
+		game.StopSpectating(m.Player)
+		s.db.RemoveSpectator(m.Player, game.ID)
+		log.Printf("Removed spectator '%s' from game '%s'\n", m.Player, m.Game)
+		return
+	}
+
+	seat, spectating := game.SpectatorSeat(m.Player)
+	if !spectating || (command != "spectate" && command != "status" && command != "chat-history") {
+		log.Printf("Attempting to make a move with nonexistent player '%s'\n", m.Player)
+		fmt.Fprint(w, jsonError("You're not a member of this game."))
+		return
+	}
+
+	if command == "chat-history" {
+		s.writeChatHistory(w, m, game)
+		return
+	}
+
+	if command == "status" {
+		if m.LastTurn == game.Table.Turn && m.UpdateTime == game.LastUpdateTime && m.ChatTime == game.LastChatTime {
+			fmt.Fprint(w, "")
+			return
+		}
+	}
+
+	state := game.CreateSpectatorState(seat, m.Empathy)
+	state.Localize(m.Language)
+	state.Chat = s.db.GetChatMessages(game.ID, 0, lib.ChatPageSize)
+	encodedGame, err := lib.EncodeGame(state)
+	if err != "" {
+		log.Printf("Failed to encode game '%s'. Error: %s\n", m.Game, err)
+		fmt.Fprint(w, jsonError("Could not transmit game state to client."))
+		return
+	}
+	fmt.Fprint(w, encodedGame)
+}
+
+// startTournamentRound creates a game for every team in a tournament's next
+// round, all dealt from the same seed.
+func (s *Server) startTournamentRound(id string) string {
+	tournament, err := s.db.GetTournament(id)
+	if err != "" {
+		return err
+	}
+	round, seed, err := s.db.StartTournamentRound(id)
+	if err != "" {
+		return err
+	}
+	for _, team := range tournament.Teams {
+		game, err := lib.NewTournamentGame(tournament, team, round, seed)
+		if err == "" {
+			game.ID, err = s.db.NewGameID()
+		}
+		if err != "" {
+			return err
+		}
+		s.db.CreateGameWithPlayers(game)
+		s.db.AddTournamentGame(id, round, team.Name, game.ID)
+		s.games[game.ID] = game
+		log.Printf("Created game '%s' for team '%s' in round %d of tournament '%s'\n", game.ID, team.Name, round, id)
+	}
+	return ""
+}
+
+// writeChatHistory sends a page of the game's chat, older than m.Before.
+func (s *Server) writeChatHistory(w http.ResponseWriter, m lib.Message, game *lib.Game) {
+	encodedChat, err := lib.EncodeChat(s.db.GetChatMessages(game.ID, m.Before, lib.ChatPageSize))
+	if err != "" {
+		log.Printf("Failed to encode chat for game '%s'. Error: %s\n", m.Game, err)
+		fmt.Fprint(w, jsonError("Could not transmit chat to client."))
+		return
+	}
+	fmt.Fprint(w, encodedChat)
+}
+
+func (s *Server) enforceTimeLimits(game *lib.Game) {
+	s.enforceClock(game)
+	s.enforceDeadline(game)
+}
+
+// enforceDeadline reminds the current player of a correspondence game once
+// half their time is up, and skips them or ends the game when all of it is.
+func (s *Server) enforceDeadline(game *lib.Game) {
+	t := time.Now().Unix()
+	if game.DeadlinePassed(t) {
+		if game.Options.DeadlineAction == lib.DeadlineEndGame {
+			log.Printf("Turn deadline passed in game '%s', ending it.", game.ID)
+			game.State = lib.StateExpired
+		} else {
+			log.Printf("Turn deadline passed in game '%s', skipping the current player.", game.ID)
+			skipError := game.SkipTurn()
+			if skipError != "" {
+				log.Printf("Failed to skip turn in game '%s'. Error: %s\n", game.ID, skipError)
+				return
+			}
+			if game.State == lib.StateExpired {
+				log.Printf("Nobody has moved for a whole round in game '%s', ending it.", game.ID)
+			}
+		}
+		// a skip isn't activity, so it doesn't hold off the abandoned-game job;
+		// only a game that has just ended counts as updated
+		if game.State != lib.StateStarted {
+			game.LastUpdateTime = t
+		}
+		if t > s.db.LastUpdateTime {
+			s.db.LastUpdateTime = t
+		}
+		s.db.SaveGameToDatabase(game)
+	} else if game.NeedsReminder(t) {
+		log.Printf("Reminding current player of turn deadline in game '%s'.", game.ID)
+		game.SendCurrentPlayerNotification()
+		game.Table.ReminderSent = true
+		s.db.SaveGameToDatabase(game)
+	}
+}
+
+// enforceClock deals with a current player who has run out of time, either by
+// ending the game or by discarding for them, depending on the game's options.
+func (s *Server) enforceClock(game *lib.Game) {
+	t := time.Now().Unix()
+	if !game.ClockExpired(t) {
+		return
+	}
+
+	if game.Options.TimeoutAction == lib.TimeoutDiscard {
+		m := game.TimeoutMove()
+		log.Printf("Player '%s' ran out of time in game '%s', discarding for them.", m.Player, game.ID)
+		processError := game.ProcessMove(&m)
+		if processError != "" {
+			log.Printf("Failed to process timeout move for game '%s'. Error: %s\n", game.ID, processError)
+			return
+		}
+		logError := s.db.LogMove(*game, m, t)
+		if logError != "" {
+			log.Printf("Failed to log timeout move for game '%s'. Error: %s\n", game.ID, logError)
+		}
+	} else {
+		log.Printf("Current player ran out of time in game '%s', ending it.", game.ID)
+		game.State = lib.StateOutOfTime
+	}
+
+	game.LastUpdateTime = t
+	if t > s.db.LastUpdateTime {
+		s.db.LastUpdateTime = t
+	}
+	s.db.SaveGameToDatabase(game)
+}
+
 func jsonError(err string) string {
 	return "{\"error\":\"" + strings.Replace(err, "\"", "\\\"", -1) + "\"}"
 }
@@ -288,6 +775,13 @@ type Server struct {
 	auth            lib.Authenticator
 	disableAuth     bool
 	clientDirectory string
+	m               sync.Mutex
+	scheduler       lib.Scheduler
+	admins          map[string]bool
+	backupDirectory string
+	cacheStats      bool
+	statsJSON       string
+	statsLock       sync.Mutex
 }
 
 func main() {
@@ -307,6 +801,17 @@ func main() {
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
 	disableAuth := flag.Bool("disable-auth", false, "Disable authentication for testing")
 	repairScore := flag.Bool("repair-score", false, "One-time repair of 0 scores")
+	migrateGameIds := flag.Bool("migrate-game-ids", false, "One-time change of old name-and-timestamp game IDs to random ones, keeping the old IDs as aliases")
+	admins := flag.String("admins", "", "Comma-separated Google IDs of players who can use admin endpoints")
+	deadlineInterval := flag.Duration("deadline-interval", lib.DefaultDeadlineInterval, "How often to check clocks and turn deadlines, 0 to disable")
+	cleanupInterval := flag.Duration("cleanup-interval", lib.DefaultCleanupInterval, "How often to delete stale unstarted games, 0 to disable")
+	unstartedLifetime := flag.Duration("unstarted-game-lifetime", lib.DefaultUnstartedGameLifetime, "How long an unstarted game is kept")
+	abandonInterval := flag.Duration("abandon-interval", lib.DefaultAbandonInterval, "How often to end abandoned games, 0 to disable")
+	abandonedLifetime := flag.Duration("abandoned-game-lifetime", lib.DefaultAbandonedGameLifetime, "How long a game can go without a move before it's ended")
+	statsInterval := flag.Duration("stats-interval", lib.DefaultStatsInterval, "How often to refresh cached stats, 0 to compute them on every request")
+	authInterval := flag.Duration("auth-eviction-interval", lib.DefaultAuthEvictionInterval, "How often to drop expired sign-ins from the cache, 0 to disable")
+	backupInterval := flag.Duration("backup-interval", lib.DefaultBackupInterval, "How often to back up the database, only used with --backup-directory")
+	backupDirectory := flag.String("backup-directory", "", "Directory to write database backups to, backups are off if empty")
 	flag.Parse()
 
 	if *cpuprofile != "" {
@@ -320,6 +825,12 @@ func main() {
 
 	s.disableAuth = *disableAuth
 	s.auth.Initialize(*disableAuth)
+	s.admins = make(map[string]bool)
+	for _, admin := range strings.Split(*admins, ",") {
+		if admin != "" {
+			s.admins[admin] = true
+		}
+	}
 
 	s.fileServer = *fileServer
 	s.clientDirectory = *clientDirectory
@@ -329,6 +840,12 @@ func main() {
 	log.Println("Loading database...")
 	s.db = new(lib.Database)
 	s.db.Connect(*databaseFile)
+
+	if *migrateGameIds {
+		log.Printf("Migrated %d game IDs.\n", s.db.MigrateLegacyGameIDs())
+		return
+	}
+
 	s.games = s.db.GetActiveGames()
 
 	log.Println("Ready to go!")
@@ -338,6 +855,19 @@ func main() {
 		return
 	}
 
+	s.backupDirectory = *backupDirectory
+	if s.backupDirectory == "" {
+		*backupInterval = 0
+	}
+	s.cacheStats = *statsInterval > 0
+	s.scheduler.Register("deadlines", *deadlineInterval, s.enforceAllTimeLimits)
+	s.scheduler.Register("stale-games", *cleanupInterval, func() string { return s.cleanupStaleGames(*unstartedLifetime) })
+	s.scheduler.Register("abandoned-games", *abandonInterval, func() string { return s.endAbandonedGames(*abandonedLifetime) })
+	s.scheduler.Register("stats", *statsInterval, func() string { s.refreshStats(); return "" })
+	s.scheduler.Register("auth-cache", *authInterval, s.evictExpiredAuth)
+	s.scheduler.Register("backup", *backupInterval, s.backupDatabase)
+	s.scheduler.Start()
+
 	if *https {
 		log.Fatal(http.ListenAndServeTLS(portString, *cert, *key, nil))
 	} else {
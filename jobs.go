@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/rschoen/fireworks-server/lib"
+)
+
+// The functions here are the server's background jobs, run by its scheduler.
+// Each returns an error string, which is empty on success.
+
+func (s *Server) enforceAllTimeLimits() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, game := range s.games {
+		s.enforceTimeLimits(game)
+	}
+	return ""
+}
+
+func (s *Server) cleanupStaleGames(lifetime time.Duration) string {
+	// hold the lock throughout so nobody joins a game as it's deleted
+	s.m.Lock()
+	defer s.m.Unlock()
+	deleted := s.db.CleanupStaleUnstartedGames(time.Now().Add(-lifetime).Unix())
+	for _, gameId := range deleted {
+		delete(s.games, gameId)
+	}
+	log.Printf("Deleted %d stale unstarted games.", len(deleted))
+	return ""
+}
+
+// endAbandonedGames ends started games nobody has moved in for too long, so
+// they stop showing up in everyone's list.
+func (s *Server) endAbandonedGames(lifetime time.Duration) string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	cutoff := time.Now().Add(-lifetime).Unix()
+	for _, game := range s.games {
+		if game.State == lib.StateStarted && game.LastUpdateTime < cutoff {
+			log.Printf("Ending abandoned game '%s'.", game.ID)
+			game.State = lib.StateExpired
+			s.db.SaveGameToDatabase(game)
+		}
+	}
+	return ""
+}
+
+func (s *Server) refreshStats() string {
+	json, err := lib.EncodeStatsMessage(s.db.CreateStatsMessage())
+	if err != "" {
+		log.Printf("Failed to encode stats log. Error: %s\n", err)
+		return ""
+	}
+
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+	s.statsJSON = json
+	return json
+}
+
+func (s *Server) cachedStats() string {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+	return s.statsJSON
+}
+
+func (s *Server) evictExpiredAuth() string {
+	log.Printf("Evicted %d expired sign-ins.", s.auth.EvictExpired())
+	return ""
+}
+
+func (s *Server) backupDatabase() string {
+	file := filepath.Join(s.backupDirectory, "fireworks-"+time.Now().Format("20060102-150405")+".db")
+	err := s.db.Backup(file)
+	if err == "" {
+		log.Printf("Backed up database to %s", file)
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package lib
+
+// An Action is one thing a player did, recorded so clients don't have to
+// parse LastMove and can show it in their own language.
+type Action struct {
+	Player       string // GoogleID of whoever acted
+	Type         int    // one of the Action constants
+	Turn         int
+	Time         int64
+	Card         *Card  // the card played or discarded
+	HintPlayer   string // GoogleID of whoever received a hint
+	HintInfoType int
+	HintColor    string
+	HintNumber   int
+	CardsTouched []int // IDs of the cards a hint touched
+	Result       int   // ResultPlay or ResultBomb for plays
+	Text         string
+}
+
+// recordAction adds an action to the table's history and keeps the player's
+// LastMove in step for clients that still read it.
+func (g *Game) recordAction(p *Player, a Action) {
+	a.Player = p.GoogleID
+	a.Turn = g.Table.Turn
+	a.Time = getCurrentTime()
+	g.Table.Actions = append(g.Table.Actions, a)
+	p.LastMove = g.FormatAction(a, DefaultLanguage)
+}
+
+// LastAction returns the most recent action by the given player, if any was
+// recorded.
+func (g *Game) LastAction(playerid string) *Action {
+	for index := len(g.Table.Actions) - 1; index >= 0; index-- {
+		if g.Table.Actions[index].Player == playerid {
+			return &g.Table.Actions[index]
+		}
+	}
+	return nil
+}
+
+// Localize rewrites each player's LastMove in the given language. Games from
+// before actions were recorded keep the English text they were saved with.
+func (g *Game) Localize(language string) {
+	for index := range g.Players {
+		if a := g.LastAction(g.Players[index].GoogleID); a != nil {
+			g.Players[index].LastMove = g.FormatAction(*a, language)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -19,6 +20,7 @@ type AuthResponse struct {
 type Authenticator struct {
 	Cache       map[string]AuthResponse
 	disableAuth bool
+	m           sync.Mutex
 }
 
 func (r *AuthResponse) GetGoogleID() string {
@@ -48,7 +50,9 @@ func (a *Authenticator) Authenticate(token string) (AuthResponse, string) {
 		return AuthResponse{}, "Trying to authenticate empty token."
 	}
 
+	a.m.Lock()
 	r, cached := a.Cache[token]
+	a.m.Unlock()
 	if !cached || r.HasExpired(AuthExpirationSeconds) {
 		// send authentication request
 		resp, err := http.Get("https://www.googleapis.com/oauth2/v3/tokeninfo?id_token=" + token)
@@ -81,8 +85,25 @@ func (a *Authenticator) Authenticate(token string) (AuthResponse, string) {
 			return AuthResponse{}, "Received expired sign-in token."
 		}
 
+		a.m.Lock()
 		a.Cache[token] = r
+		a.m.Unlock()
 	}
 
 	return r, ""
 }
+
+// EvictExpired drops cached sign-ins that would have to be checked again
+// anyway, and returns how many there were.
+func (a *Authenticator) EvictExpired() int {
+	a.m.Lock()
+	defer a.m.Unlock()
+	evicted := 0
+	for token, r := range a.Cache {
+		if r.HasExpired(AuthExpirationSeconds) {
+			delete(a.Cache, token)
+			evicted++
+		}
+	}
+	return evicted
+}
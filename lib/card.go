@@ -1,9 +1,65 @@
 package lib
 
+import "sort"
+
 type Card struct {
 	ID          int
 	Number      int
 	Color       string
 	KnownNumber int
 	KnownColor  string
+
+	PossibleColors  []string
+	PossibleNumbers []int
+	Empathy         []CardCount
+	Status          int
+}
+
+// CardCount is one identity a hidden card might have, and how many copies of
+// that identity are still unaccounted for.
+type CardCount struct {
+	Color  string
+	Number int
+	Count  int
+}
+
+// ResetPossibilities marks every color and number as still possible for this
+// card, which is all a player knows about a card before it is hinted.
+func (c *Card) ResetPossibilities(v *Variant) {
+	c.PossibleColors = v.Colors()
+	c.PossibleNumbers = v.Numbers()
+}
+
+// narrowPossibilities drops every color and number that only appears in
+// identities that would have reacted to a hint differently than this card did.
+// The lists are always rebuilt rather than edited in place, since copies of a
+// hand share them.
+func (c *Card) narrowPossibilities(touched bool, touches func(Card) bool) {
+	colors := make([]string, 0, len(c.PossibleColors))
+	possibleNumbers := make([]int, 0, len(c.PossibleNumbers))
+	for _, color := range c.PossibleColors {
+		for _, number := range c.PossibleNumbers {
+			if touches(Card{Color: color, Number: number}) != touched {
+				continue
+			}
+			if !containsColor(colors, color) {
+				colors = append(colors, color)
+			}
+			if !containsNumber(possibleNumbers, number) {
+				possibleNumbers = append(possibleNumbers, number)
+			}
+		}
+	}
+	c.PossibleColors = colors
+	sort.Ints(possibleNumbers)
+	c.PossibleNumbers = possibleNumbers
+}
+
+func containsNumber(numbers []int, number int) bool {
+	for _, n := range numbers {
+		if n == number {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// A ChallengeResult is how one group did on a daily challenge deal.
+type ChallengeResult struct {
+	GameID       string
+	Players      string
+	Score        int
+	Turns        int
+	State        int
+	TimeFinished int64
+}
+
+// A ChallengeBoard ranks everyone who played the same daily deal: highest
+// score first, then fewest turns, then whoever finished first.
+type ChallengeBoard struct {
+	Date       string
+	Mode       int
+	NumPlayers int
+	Results    []ChallengeResult
+}
+
+// ChallengeDate names the day a challenge deal belongs to.
+func ChallengeDate(t time.Time) string {
+	return t.UTC().Format(ChallengeDateFormat)
+}
+
+// ChallengeSeed works out the deal for a day, variant and number of players.
+// It is keyed with a secret kept in the database so that nobody can work out
+// the deck from the date ahead of time.
+func (db *Database) ChallengeSeed(date string, mode int, numPlayers int) int64 {
+	mac := hmac.New(sha256.New, []byte(db.challengeSecret()))
+	mac.Write([]byte(fmt.Sprintf("%s/%d/%d", date, mode, numPlayers)))
+	return seedFromBytes(mac.Sum(nil))
+}
+
+func (db *Database) challengeSecret() string {
+	row := db.dbRef.QueryRow(`select value from settings where key='challenge_secret'`)
+	var secret string
+	switch err := row.Scan(&secret); err {
+	case sql.ErrNoRows:
+		secret, tokenError := randomToken(ChallengeSecretBytes)
+		if tokenError != "" {
+			log.Fatal(tokenError)
+		}
+		db.execQuery(`insert into settings (key, value) values ('challenge_secret', ?)`, secret)
+		return secret
+	case nil:
+		return secret
+	default:
+		log.Fatal(err)
+	}
+	return ""
+}
+
+// recordChallengeResult is called every time a finished challenge game is
+// saved, and only adds it to the leaderboard the first time. Games ended by
+// vote or left to expire aren't results.
+func (db *Database) recordChallengeResult(game *Game) {
+	if game.Challenge == "" || !GameStateIsFinished(game.State) || game.State == StateTerminated || game.State == StateExpired {
+		return
+	}
+	db.execWithinTransaction(`insert or ignore into challenge_results (game_id, date, mode, players, score, turns, state, time_finished)
+		values (?, ?, ?, ?, ?, ?, ?, ?)`,
+		game.ID, game.Challenge, game.Mode, len(game.Players), game.CurrentScore, game.TurnsTaken(), game.State, getCurrentTime())
+}
+
+// ChallengeAlreadyPlayed returns the first of the players who has already
+// been dealt a day's deal, or "" if none of them has. Once someone has seen
+// the deck, playing it again wouldn't be a fair result for the leaderboard.
+func (db *Database) ChallengeAlreadyPlayed(date string, mode int, players []Player) string {
+	for _, player := range players {
+		row := db.dbRef.QueryRow(`select count(*) from games
+			join game_players on game_players.game_id=games.id
+			where games.challenge=? and games.mode=? and games.players=? and games.state<>? and game_players.player_id=?`,
+			date, mode, len(players), StateNotStarted, player.GoogleID)
+		var played int
+		if err := row.Scan(&played); err != nil {
+			log.Fatal(err)
+		}
+		if played > 0 {
+			return player.GoogleID
+		}
+	}
+	return ""
+}
+
+// GetChallengeBoard lists the results for a day's deal. A numPlayers of 0
+// includes every player count.
+func (db *Database) GetChallengeBoard(date string, mode int, numPlayers int) ChallengeBoard {
+	board := ChallengeBoard{Date: date, Mode: mode, NumPlayers: numPlayers, Results: make([]ChallengeResult, 0)}
+	rows, err := db.dbRef.Query(`select challenge_results.game_id, coalesce(group_concat(players.name, ', '), ''),
+		challenge_results.score, challenge_results.turns, challenge_results.state, challenge_results.time_finished
+		from challenge_results
+		left join game_players on game_players.game_id=challenge_results.game_id
+		left join players on players.id=game_players.player_id
+		where challenge_results.date=? and challenge_results.mode=? and (?=0 or challenge_results.players=?)
+		group by challenge_results.game_id
+		order by challenge_results.score desc, challenge_results.turns, challenge_results.time_finished
+		limit ?`, date, mode, numPlayers, numPlayers, MaxChallengeResults)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result ChallengeResult
+		if err = rows.Scan(&result.GameID, &result.Players, &result.Score, &result.Turns, &result.State, &result.TimeFinished); err != nil {
+			log.Fatal(err)
+		}
+		board.Results = append(board.Results, result)
+	}
+	return board
+}
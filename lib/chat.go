@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type ChatMessage struct {
+	ID     int64
+	Player string
+	Name   string
+	Time   int64
+	Text   string
+}
+
+// SanitizeChat trims a chat message or announcement, drops control characters
+// and escapes HTML so clients can display it as-is.
+func SanitizeChat(text string) (string, string) {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", "Attempting to send an empty message."
+	}
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		return "", "Attempting to send a message that is too long."
+	}
+	return html.EscapeString(text), ""
+}
@@ -1,5 +1,7 @@
 package lib
 
+import "time"
+
 const VERSION = "2.0.2"
 
 const HintNumber = 1
@@ -9,12 +11,27 @@ const MovePlay = 1
 const MoveDiscard = 2
 const MoveHint = 3
 
+// kinds of Action, the first three matching the move types
+const ActionPlay = MovePlay
+const ActionDiscard = MoveDiscard
+const ActionHint = MoveHint
+const ActionSkip = 4
+const ActionAnnounce = 5
+const ActionProposeEnd = 6
+const ActionVoteEnd = 7
+const ActionVoteContinue = 8
+
+const DefaultLanguage = "en"
+
 const StateNotStarted = 1
 const StateStarted = 2
 const StateBombedOut = 3
 const StatePerfect = 4
 const StateDeckEmpty = 5
 const StateNoPlays = 6
+const StateOutOfTime = 7
+const StateExpired = 8
+const StateTerminated = 9
 
 func GameStateIsFinished(state int) bool {
 	if state != StateNotStarted && state != StateStarted {
@@ -24,26 +41,56 @@ func GameStateIsFinished(state int) bool {
 	}
 }
 
+const CardStatusUnknown = 0
+const CardStatusNeeded = 1
+const CardStatusTrash = 2
+const CardStatusCritical = 3
+const CardStatusDead = 4
+
 const ModeNormal = 1
 const ModeRainbow = 2
 const ModeWildcard = 3
 const ModeHard = 4
 const ModeRainbowLimited = 5
-const Modes = 5
+const ModeBlack = 6
+const ModeNull = 7
+const ModePink = 8
+const ModeBrown = 9
+const ModeUpOrDown = 10
+
+// which hints touch the cards of a suit
+const TouchOwn = 1
+const TouchAll = 2
+const TouchNone = 3
 
 const ColorRainbow = "rainbow"
+const ColorBlack = "black"
+const ColorNull = "colorless"
+const ColorPink = "pink"
+const ColorBrown = "brown"
+
+// Number of the START cards that can begin an up-or-down pile
+const NumberStart = 6
+
+const PileUndecided = 0
+const PileUp = 1
+const PileDown = 2
+const PileStarted = 3 // by a START card, so it can still go either way
+
+// KnownNumber of a card that has been touched by two different number hints
+const KnownNumberAny = -1
 
 const ResultOther = 0
 const ResultPlay = 1
 const ResultBomb = 2
 
 var normalColors = [...]string{"red", "green", "blue", "yellow", "white"}
-var rainbowColors = [...]string{"red", "green", "blue", "yellow", "white", ColorRainbow}
-var numbers = [...]int{0, 3, 2, 2, 2, 1} // this represents the COUNTS of each number (0 added for simplicity)
+var numbers = [...]int{0, 3, 2, 2, 2, 1}       // this represents the COUNTS of each number (0 added for simplicity)
+var singleNumbers = [...]int{0, 1, 1, 1, 1, 1} // one of each, for suits where every card is critical
+var upOrDownNumbers = [...]int{0, 1, 2, 2, 2, 1}
 
 var cardsInHand = [...]int{0, 0, 5, 5, 4, 4} // this represents the COUNTS for each # of players
 const MaxPlayers = 5
-const MaxScoreAllModes = 30
 
 const MaxConcurrentGames = 100
 const MaxStoredGames = 1000
@@ -54,9 +101,63 @@ const DefaultKey = "server.key"
 const DefaultDatabaseFile = "database.db"
 const AuthExpirationSeconds = 7 * 24 * 60 * 60
 
+// how often background jobs run by default, and what they consider stale
+const DefaultDeadlineInterval = time.Minute
+const DefaultCleanupInterval = time.Hour
+const DefaultAbandonInterval = time.Hour
+const DefaultStatsInterval = 5 * time.Minute
+const DefaultAuthEvictionInterval = time.Hour
+const DefaultBackupInterval = 24 * time.Hour
+const DefaultUnstartedGameLifetime = 7 * 24 * time.Hour
+const DefaultAbandonedGameLifetime = 30 * 24 * time.Hour
+
+// what happens when a player's clock runs out in a timed game
+const TimeoutEndGame = 1
+const TimeoutDiscard = 2
+
+const MaxTimeBank = 7 * 24 * 60 * 60
+
+// what happens when a correspondence game's turn deadline passes
+const DeadlineSkip = 1
+const DeadlineEndGame = 2
+
+const MinTurnDeadline = 60 * 60
+
+// the seat a spectator who can see every hand watches from
+const SeatOmniscient = -1
+
 const MaxHints = 8
 const StartingHints = 8
 const StartingBombs = 3
 
 const MaxPlayerNameLength = 10
 const MaxGameNameLength = 20
+const MaxChatLength = 500
+
+// chat messages sent with the game state, and in each page of history
+const ChatPageSize = 20
+
+// random bytes in a private game's invite code
+const InviteCodeBytes = 12
+
+// random bytes in a game ID, and how many times to retry if one is taken
+const GameIDBytes = 12
+const GameIDAttempts = 5
+
+const MaxSeriesLength = 100
+
+const ChallengeDateFormat = "2006-01-02"
+const ChallengeSecretBytes = 32
+const MaxChallengeResults = 100
+
+const MaxTournamentRounds = 20
+
+// player ratings: everyone starts at InitialRating, and a team that scores a
+// perfect game when it was expected to get none gains RatingK
+const InitialRating = 1500.0
+const RatingK = 200.0
+const RatingSpread = 2000.0 // rating above InitialRating per extra share of a perfect score expected
+const DefaultExpectedFraction = 0.5
+const MinRatingSampleGames = 20 // finished games needed before their average score is used as the expectation
+const MinRatedGames = 5
+const MaxLeaderboardSize = 100
@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"regexp"
 	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -25,6 +27,106 @@ func (db *Database) Connect(dbFile string) {
 	}
 	db.dbRef = dbRef
 	db.tx = nil
+	db.migrate()
+}
+
+// migrate brings a database created by an older version of the server up to
+// date. Every step has to be safe to run again on an up-to-date database.
+func (db *Database) migrate() {
+	db.addColumnIfMissing("games", "options", "text not null default ''")
+	db.addColumnIfMissing("games", "time_created", "integer not null default 0")
+	// unstarted games from before creation times were kept count as created
+	// now, so the stale-games job gives them a full lifetime too
+	db.execQuery(`update games set time_created=? where time_created=0 and state=?`, time.Now().Unix(), StateNotStarted)
+	db.addColumnIfMissing("games", "host", "text not null default ''")
+	// only right after adding the column, since a private game without a code
+	// from then on is one whose host revoked it
+	if db.addColumnIfMissing("games", "invite_code", "text not null default ''") {
+		db.addInviteCodes()
+	}
+	db.addColumnIfMissing("games", "previous_game", "text not null default ''")
+	db.addColumnIfMissing("games", "next_game", "text not null default ''")
+	db.addColumnIfMissing("games", "series_id", "text not null default ''")
+	db.addColumnIfMissing("games", "challenge", "text not null default ''")
+	db.execQuery(`create table if not exists settings (key text primary key, value text not null)`)
+	db.execQuery(`create table if not exists challenge_results (game_id text primary key, date text not null, mode int not null, players int not null, score int not null, turns int not null, state int not null, time_finished integer not null)`)
+	db.execQuery(`create index if not exists challenge_results_date on challenge_results (date, mode, players)`)
+	db.execQuery(`create table if not exists tournaments (id text primary key, name text not null, mode int not null, team_size int not null, rounds int not null, current_round int not null, options text not null, time_created integer not null)`)
+	db.execQuery(`create table if not exists tournament_players (tournament_id text not null, team text not null, player_id text not null, seat int not null, primary key (tournament_id, player_id))`)
+	db.execQuery(`create table if not exists tournament_rounds (tournament_id text not null, round int not null, seed integer not null, primary key (tournament_id, round))`)
+	db.execQuery(`create table if not exists tournament_games (tournament_id text not null, round int not null, team text not null, game_id text not null, primary key (tournament_id, round, team))`)
+	db.execQuery(`create table if not exists ratings (player_id text not null, mode int not null, rating real not null, games int not null, primary key (player_id, mode))`)
+	db.execQuery(`create table if not exists rating_history (id integer primary key autoincrement, player_id text not null, mode int not null, game_id text not null, rating_before real not null, rating_after real not null, time integer not null)`)
+	db.execQuery(`create index if not exists rating_history_game on rating_history (game_id)`)
+	db.execQuery(`create table if not exists series (id text primary key, name text not null, host text not null, length int not null, time_created integer not null)`)
+	db.execQuery(`create table if not exists game_aliases (alias text primary key, game_id text not null)`)
+	db.addUniqueGameIDIndex()
+	db.execQuery(`create table if not exists chat_messages (id integer primary key autoincrement, game_id text not null, player_id text not null, time integer not null, text text not null)`)
+	db.execQuery(`create index if not exists chat_messages_game on chat_messages (game_id, id)`)
+	db.execQuery(`create table if not exists game_spectators (game_id text not null, player_id text not null, seat int not null, primary key (game_id, player_id))`)
+}
+
+// addUniqueGameIDIndex makes sure no two games can share an ID. Databases
+// where that already happened are left alone rather than refusing to start.
+func (db *Database) addUniqueGameIDIndex() {
+	row := db.dbRef.QueryRow(`select count(*) from (select id from games group by id having count(*) > 1)`)
+	var duplicates int
+	if err := row.Scan(&duplicates); err != nil {
+		log.Fatal(err)
+	}
+	if duplicates > 0 {
+		log.Printf("Found %d game IDs used more than once, not enforcing unique game IDs.\n", duplicates)
+		return
+	}
+	db.execQuery(`create unique index if not exists games_id on games (id)`)
+}
+
+// addColumnIfMissing adds a column to a table unless it's already there, and
+// says whether it had to.
+func (db *Database) addColumnIfMissing(table string, column string, definition string) bool {
+	rows, err := db.dbRef.Query(`select name from pragma_table_info(?)`, table)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if name == column {
+			return false
+		}
+	}
+	db.execQuery("alter table " + table + " add column " + column + " " + definition)
+	return true
+}
+
+// addInviteCodes gives private games that were waiting for players before
+// invite codes existed a code of their own, so they can still be joined.
+func (db *Database) addInviteCodes() {
+	rows, err := db.dbRef.Query(`select id from games where public=0 and state=? and invite_code=''`, StateNotStarted)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			log.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		code, codeError := randomToken(InviteCodeBytes)
+		if codeError != "" {
+			log.Fatal(codeError)
+		}
+		db.execQuery(`update games set invite_code=? where id=?`, code, id)
+	}
 }
 
 func (db *Database) openTransaction() {
@@ -165,9 +267,9 @@ func (db *Database) LookupGameById(id string) *Game {
 	row := db.dbRef.QueryRow(`select name,
 		state, time_started, last_move_time, turns, timed_turns,
 		turn_time, game_time, plays, bombs, discards, hints,
-		score, mode, players, public, ignore_time, sigh_button, table_state
+		score, mode, players, public, ignore_time, sigh_button, table_state, options, host, invite_code, previous_game, next_game, series_id, challenge
 		 												from games where id=?`, id)
-	var name, tableState string
+	var name, tableState, optionsState, host, inviteCode, previousGame, nextGame, seriesId, challenge string
 	var public, ignoreTime, sighButton bool
 	var state, lastMoveTime, turns, timedTurns,
 		plays, bombs, discards, hints, score, mode, players int
@@ -176,7 +278,7 @@ func (db *Database) LookupGameById(id string) *Game {
 	switch err := row.Scan(&name,
 		&state, &timeStarted, &lastMoveTime, &turns, &timedTurns,
 		&turnTime, &gameTime, &plays, &bombs, &discards, &hints,
-		&score, &mode, &players, &public, &ignoreTime, &sighButton, &tableState); err {
+		&score, &mode, &players, &public, &ignoreTime, &sighButton, &tableState, &optionsState, &host, &inviteCode, &previousGame, &nextGame, &seriesId, &challenge); err {
 	case sql.ErrNoRows:
 		fmt.Println("Game not found: " + id)
 	case nil:
@@ -209,6 +311,24 @@ func (db *Database) LookupGameById(id string) *Game {
 		game.Table = &table
 		game.Players = db.GetGamePlayers(id)
 
+		options, err := DecodeOptions(optionsState)
+		if err != "" {
+			log.Fatal(err)
+		}
+		game.Options = options
+
+		game.Host = host
+		game.InviteCode = inviteCode
+		game.PreviousGame = previousGame
+		game.NextGame = nextGame
+		game.Series = seriesId
+		game.Challenge = challenge
+		game.SetSpectators(db.GetGameSpectators(id))
+		if game.Host == "" && len(game.Players) > 0 {
+			// games created before hosts were recorded
+			game.Host = game.Players[0].GoogleID
+		}
+
 		return game
 
 	default:
@@ -237,19 +357,150 @@ func (db *Database) SaveGameToDatabase(game *Game) {
 
 		db.execWithinTransaction(`update game_players set last_move=?, hand_state=? where game_id=? AND player_id=?`, player.LastMove, cardJson, game.ID, player.GoogleID)
 	}
+	db.recordChallengeResult(game)
 
 	db.closeTransaction()
+	db.updateRatings(game)
 }
+
+// NewGameID picks a random game ID that no game or alias is using yet.
+func (db *Database) NewGameID() (string, string) {
+	for attempt := 0; attempt < GameIDAttempts; attempt++ {
+		id, err := NewGameID()
+		if err != "" {
+			return "", err
+		}
+		if !db.GameIDExists(id) {
+			return id, ""
+		}
+	}
+	return "", "Could not find an unused game ID."
+}
+
+func (db *Database) GameIDExists(id string) bool {
+	row := db.dbRef.QueryRow(`select (select count(*) from games where id=?) + (select count(*) from game_aliases where alias=?)`, id, id)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+// ResolveGameID turns an ID a game used to have into the one it has now.
+// IDs that aren't aliases are returned as they are.
+func (db *Database) ResolveGameID(id string) string {
+	row := db.dbRef.QueryRow(`select game_id from game_aliases where alias=?`, id)
+	var gameId string
+	switch err := row.Scan(&gameId); err {
+	case sql.ErrNoRows:
+		return id
+	case nil:
+		return gameId
+	default:
+		log.Fatal(err)
+	}
+	return id
+}
+
+// legacy IDs were the game's name and the Unix time it was created
+var legacyGameID = regexp.MustCompile(`^.+-[0-9]{10}$`)
+
+// MigrateLegacyGameIDs gives every game that still has a name-and-timestamp ID
+// a random one, keeping the old ID as an alias so links to it keep working.
+// Games that were given the same ID get one each; the first of them is the one
+// that ID has been loading, so it keeps the alias and everything else that
+// refers to the old ID. There's no telling which of the rest those players,
+// spectators and messages were in, so the rest are left with nobody and ended.
+func (db *Database) MigrateLegacyGameIDs() int {
+	rows, err := db.dbRef.Query(`select rowid, id from games where id not in (select game_id from game_aliases) order by rowid`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rowIds []int64
+	var legacyIds []string
+	for rows.Next() {
+		var rowId int64
+		var id string
+		if err = rows.Scan(&rowId, &id); err != nil {
+			log.Fatal(err)
+		}
+		if legacyGameID.MatchString(id) {
+			rowIds = append(rowIds, rowId)
+			legacyIds = append(legacyIds, id)
+		}
+	}
+	rows.Close()
+
+	migrated := make(map[string]bool)
+	for index, oldId := range legacyIds {
+		newId, idError := db.NewGameID()
+		if idError != "" {
+			log.Fatal(idError)
+		}
+		db.openTransaction()
+		db.execWithinTransaction(`update games set id=? where rowid=?`, newId, rowIds[index])
+		if !migrated[oldId] {
+			db.renameGameWithinTransaction(oldId, newId)
+			db.execWithinTransaction(`insert into game_aliases (alias, game_id) values (?, ?)`, oldId, newId)
+			migrated[oldId] = true
+		} else {
+			log.Printf("Game '%s' shared its ID with an earlier game, ending it as '%s'.\n", oldId, newId)
+			db.execWithinTransaction(`update games set state=?, players=0 where rowid=?`, StateTerminated, rowIds[index])
+		}
+		db.closeTransaction()
+	}
+
+	db.addUniqueGameIDIndex()
+	return len(legacyIds)
+}
+
+// renameGameWithinTransaction points everything that refers to a game by ID
+// at its new one.
+func (db *Database) renameGameWithinTransaction(oldId string, newId string) {
+	db.execWithinTransaction(`update game_players set game_id=? where game_id=?`, newId, oldId)
+	db.execWithinTransaction(`update game_aliases set game_id=? where game_id=?`, newId, oldId)
+	db.execWithinTransaction(`update game_spectators set game_id=? where game_id=?`, newId, oldId)
+	db.execWithinTransaction(`update chat_messages set game_id=? where game_id=?`, newId, oldId)
+	db.execWithinTransaction(`update challenge_results set game_id=? where game_id=?`, newId, oldId)
+	db.execWithinTransaction(`update tournament_games set game_id=? where game_id=?`, newId, oldId)
+	db.execWithinTransaction(`update rating_history set game_id=? where game_id=?`, newId, oldId)
+	db.execWithinTransaction(`update games set previous_game=? where previous_game=?`, newId, oldId)
+	db.execWithinTransaction(`update games set next_game=? where next_game=?`, newId, oldId)
+}
+
 func (db *Database) CreateGame(game Game) {
+	db.openTransaction()
+	db.createGameWithinTransaction(game)
+	db.closeTransaction()
+}
+
+// CreateGameWithPlayers saves a new game that already has its players, such
+// as a rematch or a tournament game, seating them in the order they're in.
+func (db *Database) CreateGameWithPlayers(game *Game) {
+	db.openTransaction()
+	db.createGameWithinTransaction(*game)
+	for index, player := range game.Players {
+		db.execWithinTransaction(`insert into game_players (game_id, player_id, player_index, last_move)
+			values (?, ?, ?, ?)`, game.ID, player.GoogleID, index, "")
+	}
+	db.closeTransaction()
+}
+
+func (db *Database) createGameWithinTransaction(game Game) {
 	json, error := EncodeTable(game.Table)
 	if error != "" {
 		log.Fatal(error)
 	}
 
-	db.execQuery(`insert into games (id, name, time_started,
-		last_move_time, mode, players, state, table_state, public, ignore_time, sigh_button) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	options, error := EncodeOptions(game.Options)
+	if error != "" {
+		log.Fatal(error)
+	}
+
+	db.execWithinTransaction(`insert into games (id, name, time_started,
+		last_move_time, mode, players, state, table_state, public, ignore_time, sigh_button, options, time_created, host, invite_code, previous_game, series_id, challenge) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		game.ID, game.Name, game.StartTime, game.LastUpdateTime, game.Mode,
-		len(game.Players), game.State, json, game.Public, game.IgnoreTime, game.SighButton)
+		len(game.Players), game.State, json, game.Public, game.IgnoreTime, game.SighButton, options, time.Now().Unix(), game.Host, game.InviteCode, game.PreviousGame, game.Series, game.Challenge)
 
 }
 func (db *Database) AddPlayer(playerId string, gameId string) {
@@ -262,6 +513,36 @@ func (db *Database) AddPlayer(playerId string, gameId string) {
 	db.closeTransaction()
 }
 
+// RemovePlayer takes a player out of a game and closes the gap they leave in
+// the seating, so player_index always matches the order of game.Players.
+func (db *Database) RemovePlayer(playerId string, game *Game) {
+	db.openTransaction()
+	db.execWithinTransaction(`delete from game_players where game_id=? and player_id=?`, game.ID, playerId)
+	db.seatPlayersWithinTransaction(game)
+	db.closeTransaction()
+}
+
+func (db *Database) SeatPlayers(game *Game) {
+	db.openTransaction()
+	db.seatPlayersWithinTransaction(game)
+	db.closeTransaction()
+}
+
+func (db *Database) seatPlayersWithinTransaction(game *Game) {
+	for index, player := range game.Players {
+		db.execWithinTransaction(`update game_players set player_index=? where game_id=? and player_id=?`, index, game.ID, player.GoogleID)
+	}
+	db.execWithinTransaction(`update games set players=?, host=? where id=?`, len(game.Players), game.Host, game.ID)
+}
+
+func (db *Database) SaveNextGame(game *Game) {
+	db.execQuery(`update games set next_game=? where id=?`, game.NextGame, game.ID)
+}
+
+func (db *Database) SaveInviteCode(game *Game) {
+	db.execQuery(`update games set invite_code=? where id=?`, game.InviteCode, game.ID)
+}
+
 func (db *Database) CreatePlayerIfNotExists(id string, name string) {
 	row := db.dbRef.QueryRow(`select name from players where id=?`, id)
 
@@ -326,6 +607,60 @@ func (db *Database) GetGamePlayers(id string) []Player {
 	return players
 }
 
+func (db *Database) GetGameSpectators(id string) map[string]int {
+	rows, err := db.dbRef.Query(`select player_id, seat from game_spectators where game_id=?`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	spectators := make(map[string]int)
+	for rows.Next() {
+		var playerId string
+		var seat int
+		if err = rows.Scan(&playerId, &seat); err != nil {
+			log.Fatal(err)
+		}
+		spectators[playerId] = seat
+	}
+	return spectators
+}
+
+func (db *Database) AddSpectator(playerId string, gameId string, seat int) {
+	db.execQuery(`insert or replace into game_spectators (game_id, player_id, seat) values (?, ?, ?)`, gameId, playerId, seat)
+}
+
+func (db *Database) RemoveSpectator(playerId string, gameId string) {
+	db.execQuery(`delete from game_spectators where game_id=? and player_id=?`, gameId, playerId)
+}
+
+func (db *Database) AddChatMessage(gameId string, message ChatMessage) {
+	db.execQuery(`insert into chat_messages (game_id, player_id, time, text) values (?, ?, ?, ?)`, gameId, message.Player, message.Time, message.Text)
+}
+
+// GetChatMessages returns up to limit of a game's messages sent before the one
+// with the given ID, or the latest ones if before is 0, oldest first.
+func (db *Database) GetChatMessages(gameId string, before int64, limit int) []ChatMessage {
+	rows, err := db.dbRef.Query(`select chat_messages.id, player_id, coalesce(players.name, ''), time, text
+		from chat_messages left join players on players.id=player_id
+		where game_id=? and (?=0 or chat_messages.id<?) order by chat_messages.id desc limit ?`, gameId, before, before, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	messages := make([]ChatMessage, 0, limit)
+	for rows.Next() {
+		var message ChatMessage
+		if err = rows.Scan(&message.ID, &message.Player, &message.Name, &message.Time, &message.Text); err != nil {
+			log.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages
+}
+
 func (db *Database) LogMove(g Game, m Message, t int64) string {
 
 	var mainPlayerSql = "turns=turns+1, "
@@ -382,9 +717,45 @@ func (db *Database) CleanupUnstartedGames() {
 	db.closeTransaction()
 }
 
+// CleanupStaleUnstartedGames deletes games that were created before the given
+// time and never started, and returns their IDs.
+func (db *Database) CleanupStaleUnstartedGames(before int64) []string {
+	rows, err := db.dbRef.Query(`select id from games where state=? and time_created<?`, StateNotStarted, before)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var gameIds = make([]string, 0)
+	for rows.Next() {
+		var gameId string
+		err = rows.Scan(&gameId)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gameIds = append(gameIds, gameId)
+	}
+	rows.Close()
+
+	for _, gameId := range gameIds {
+		db.DeleteGame(gameId)
+	}
+	return gameIds
+}
+
+// Backup writes a consistent copy of the whole database to a new file.
+func (db *Database) Backup(file string) string {
+	_, err := db.dbRef.Exec(`vacuum into ?`, file)
+	if err != nil {
+		return "Error backing up database to " + file + ": " + err.Error()
+	}
+	return ""
+}
+
 func (db *Database) DeleteGame(gameid string) {
 	db.openTransaction()
 	db.execWithinTransaction(`delete from games where id=?`, gameid)
 	db.execWithinTransaction(`delete from game_players where game_id=?`, gameid)
+	db.execWithinTransaction(`delete from game_aliases where game_id=?`, gameid)
+	db.execWithinTransaction(`delete from game_spectators where game_id=?`, gameid)
+	db.execWithinTransaction(`delete from chat_messages where game_id=?`, gameid)
 	db.closeTransaction()
 }
@@ -0,0 +1,125 @@
+package lib
+
+import "fmt"
+
+// A phrasebook holds the wording of every kind of action in one language.
+type phrasebook struct {
+	Played        string
+	Bombed        string
+	Discarded     string
+	HintedNumber  string
+	HintedColor   string
+	Skipped       string
+	Announced     string
+	ProposedEnd   string
+	VotedEnd      string
+	VotedContinue string
+	Card          string // color then number
+	Start         string
+	Colors        map[string]string
+}
+
+var phrasebooks = map[string]*phrasebook{
+	"en": {
+		Played:        "played %s",
+		Bombed:        "bombed %s",
+		Discarded:     "discarded %s",
+		HintedNumber:  "➡ %s %ds",
+		HintedColor:   "➡ %s %ss",
+		Skipped:       "ran out of time",
+		Announced:     ": %s",
+		ProposedEnd:   "proposed ending the game",
+		VotedEnd:      "voted to end the game",
+		VotedContinue: "voted to keep playing",
+		Card:          "%s %s",
+		Start:         "start",
+	},
+	"fr": {
+		Played:        "a joué %s",
+		Bombed:        "a raté %s",
+		Discarded:     "a défaussé %s",
+		HintedNumber:  "➡ %s : les %d",
+		HintedColor:   "➡ %s : couleur %s",
+		Skipped:       "a manqué de temps",
+		Announced:     " : %s",
+		ProposedEnd:   "a proposé d'arrêter la partie",
+		VotedEnd:      "a voté pour arrêter la partie",
+		VotedContinue: "a voté pour continuer",
+		Card:          "%s %s",
+		Start:         "départ",
+		Colors: map[string]string{"red": "rouge", "green": "vert", "blue": "bleu", "yellow": "jaune", "white": "blanc",
+			ColorRainbow: "arc-en-ciel", ColorBlack: "noir", ColorNull: "incolore", ColorPink: "rose", ColorBrown: "marron"},
+	},
+	"es": {
+		Played:        "jugó %s",
+		Bombed:        "falló %s",
+		Discarded:     "descartó %s",
+		HintedNumber:  "➡ %s: los %d",
+		HintedColor:   "➡ %s: color %s",
+		Skipped:       "se quedó sin tiempo",
+		Announced:     ": %s",
+		ProposedEnd:   "propuso terminar la partida",
+		VotedEnd:      "votó por terminar la partida",
+		VotedContinue: "votó por seguir jugando",
+		Card:          "%s %s",
+		Start:         "inicio",
+		Colors: map[string]string{"red": "rojo", "green": "verde", "blue": "azul", "yellow": "amarillo", "white": "blanco",
+			ColorRainbow: "arcoíris", ColorBlack: "negro", ColorNull: "incoloro", ColorPink: "rosa", ColorBrown: "marrón"},
+	},
+}
+
+// FormatAction describes an action in the given language, falling back to
+// DefaultLanguage for languages without a phrasebook.
+func (g *Game) FormatAction(a Action, language string) string {
+	book, ok := phrasebooks[language]
+	if !ok {
+		book = phrasebooks[DefaultLanguage]
+	}
+
+	switch a.Type {
+	case ActionPlay:
+		if a.Result == ResultBomb {
+			return fmt.Sprintf(book.Bombed, book.cardName(a.Card))
+		}
+		return fmt.Sprintf(book.Played, book.cardName(a.Card))
+	case ActionDiscard:
+		return fmt.Sprintf(book.Discarded, book.cardName(a.Card))
+	case ActionHint:
+		name := ""
+		if receiver := g.GetPlayerByGoogleID(a.HintPlayer); receiver != nil {
+			name = receiver.Name
+		}
+		if a.HintInfoType == HintNumber {
+			return fmt.Sprintf(book.HintedNumber, name, a.HintNumber)
+		}
+		return fmt.Sprintf(book.HintedColor, name, book.color(a.HintColor))
+	case ActionSkip:
+		return book.Skipped
+	case ActionAnnounce:
+		return fmt.Sprintf(book.Announced, a.Text)
+	case ActionProposeEnd:
+		return book.ProposedEnd
+	case ActionVoteEnd:
+		return book.VotedEnd
+	case ActionVoteContinue:
+		return book.VotedContinue
+	}
+	return ""
+}
+
+func (book *phrasebook) color(color string) string {
+	if translated, ok := book.Colors[color]; ok {
+		return translated
+	}
+	return color
+}
+
+func (book *phrasebook) cardName(c *Card) string {
+	if c == nil {
+		return ""
+	}
+	if c.Number == NumberStart {
+		return fmt.Sprintf(book.Card, book.color(c.Color), book.Start)
+	}
+	return fmt.Sprintf(book.Card, book.color(c.Color), fmt.Sprint(c.Number))
+}
@@ -2,10 +2,10 @@ package lib
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"math/rand"
-	"strconv"
 	"time"
 
 	firebase "firebase.google.com/go"
@@ -24,19 +24,50 @@ type Game struct {
 	State          int
 	StartTime      int64
 	LastUpdateTime int64
+	LastChatTime   int64 // when the last chat message was sent, which isn't activity in the game itself
 	Mode           int
 	CurrentScore   int
 	Table          *Table
 
-	Stats StatLog
+	Host           string // GoogleID of the player who runs the game before it starts
+	PreviousGame   string // ID of the game this is a rematch of
+	NextGame       string // ID of this game's rematch
+	Series         string // ID of the series this game is part of, if any
+	Challenge      string // the day whose challenge deal this game is played on, if any
+	InviteCode     string // needed to join a private game, and only shown to the host
+	Options        GameOptions
+	Stats          StatLog
+	AvailableMoves []LegalMove
+	SpectatorCount int
+	Chat           []ChatMessage // the most recent messages, filled in by the server
+
+	spectators map[string]int // each spectator's GoogleID and the seat they watch from
 }
 
-func (g *Game) Initialize(public bool, ignoreTime bool, sighButton bool, gameMode int) string {
+// GameOptions are the rules chosen when a game is created, other than the
+// ones that have always had a column of their own.
+type GameOptions struct {
+	TimeBank      int64 // seconds on each player's clock, or 0 for no clock
+	TimeIncrement int64 // seconds added to a player's clock after each of their turns
+	TimeoutAction int   // TimeoutEndGame or TimeoutDiscard
+
+	TurnDeadline   int64 // seconds a player has to move before DeadlineAction is taken, or 0 for none
+	DeadlineAction int   // DeadlineSkip or DeadlineEndGame
+
+	UnanimousTermination bool // every player, rather than a majority, must vote to end the game early
+
+	AllowSpectators      bool
+	OmniscientSpectators bool // spectators may see every hand while the game is still being played
+
+	DisableChat bool // no table talk: neither chat nor announcements are allowed
+}
+
+func (g *Game) Initialize(public bool, ignoreTime bool, sighButton bool, gameMode int, options GameOptions) string {
 	g.State = StateNotStarted
 	g.Table = new(Table)
 
 	// validate input
-	if gameMode != ModeNormal && gameMode != ModeRainbow && gameMode != ModeWildcard && gameMode != ModeHard && gameMode != ModeRainbowLimited {
+	if GetVariant(gameMode) == nil {
 		gameMode = ModeNormal
 	}
 	g.Mode = gameMode
@@ -52,6 +83,35 @@ func (g *Game) Initialize(public bool, ignoreTime bool, sighButton bool, gameMod
 	g.Mode = gameMode
 	g.LastUpdateTime = -1
 
+	if options.TimeBank < 0 || options.TimeIncrement < 0 {
+		return "Attempting to create a game with a negative clock."
+	}
+	if options.TimeBank > MaxTimeBank {
+		options.TimeBank = MaxTimeBank
+	}
+	if options.TimeIncrement > MaxTimeBank {
+		options.TimeIncrement = MaxTimeBank
+	}
+	if options.TimeoutAction != TimeoutDiscard {
+		options.TimeoutAction = TimeoutEndGame
+	}
+	if options.TurnDeadline < 0 {
+		return "Attempting to create a game with a negative turn deadline."
+	}
+	if options.TurnDeadline > 0 && options.TurnDeadline < MinTurnDeadline {
+		options.TurnDeadline = MinTurnDeadline
+	}
+	if options.DeadlineAction != DeadlineEndGame {
+		options.DeadlineAction = DeadlineSkip
+	}
+	g.Options = options
+
+	if !public {
+		if err := g.RegenerateInviteCode(); err != "" {
+			return err
+		}
+	}
+
 	// start with no Players
 	g.Players = make([]Player, 0, len(cardsInHand)-1)
 	g.Table.HighestPossibleScore = g.GetHighestPossibleScore()
@@ -72,6 +132,174 @@ func (g *Game) AddPlayer(id string, name string) string {
 	return ""
 }
 
+// CanJoin reports whether a player holding the given invite code may join.
+// Public games and the host need no code; otherwise private games whose code
+// was revoked admit no one.
+func (g *Game) CanJoin(playerid string, inviteCode string) bool {
+	if g.Public || playerid == g.Host {
+		return true
+	}
+	return g.InviteCode != "" && subtle.ConstantTimeCompare([]byte(g.InviteCode), []byte(inviteCode)) == 1
+}
+
+// RegenerateInviteCode replaces a private game's invite code, so links shared
+// with the old one stop working.
+func (g *Game) RegenerateInviteCode() string {
+	code, err := randomToken(InviteCodeBytes)
+	if err != "" {
+		return err
+	}
+	g.InviteCode = code
+	return ""
+}
+
+func (g *Game) RevokeInviteCode() {
+	g.InviteCode = ""
+}
+
+// NewChatMessage checks that a player may send the given message, and returns it ready
+// to be stored.
+func (g *Game) NewChatMessage(playerid string, text string) (ChatMessage, string) {
+	if g.Options.DisableChat {
+		return ChatMessage{}, "Attempting to chat in a game without table talk."
+	}
+	p := g.GetPlayerByGoogleID(playerid)
+	if p == nil {
+		return ChatMessage{}, "Attempting to chat as a nonexistent player."
+	}
+	text, err := SanitizeChat(text)
+	if err != "" {
+		return ChatMessage{}, err
+	}
+	g.LastChatTime = getCurrentTime()
+	return ChatMessage{Player: playerid, Name: p.Name, Time: g.LastChatTime, Text: text}, ""
+}
+
+// Rematch sets up a new game with the same players, seats and rules as this
+// finished one. If rotate is set, the player after whoever started this game
+// goes first instead of someone picked at random.
+func (g *Game) Rematch(rotate bool) (*Game, string) {
+	if !GameStateIsFinished(g.State) {
+		return nil, "Attempting to rematch a game that isn't over."
+	}
+	if g.NextGame != "" {
+		return nil, "Attempting to rematch a game that already has a rematch."
+	}
+
+	rematch := new(Game)
+	if err := rematch.Initialize(g.Public, g.IgnoreTime, g.SighButton, g.Mode, g.Options); err != "" {
+		return nil, err
+	}
+	rematch.Name = g.Name
+	rematch.Host = g.Host
+	rematch.PreviousGame = g.ID
+	rematch.Series = g.Series
+	for _, player := range g.Players {
+		if err := rematch.AddPlayer(player.GoogleID, player.Name); err != "" {
+			return nil, err
+		}
+	}
+	if rotate && len(g.Players) > 0 {
+		rematch.Table.StartingPlayer = (g.Table.StartingPlayer + 1) % len(g.Players)
+		rematch.Table.StartingPlayerChosen = true
+	}
+	return rematch, ""
+}
+
+// Spectate lets someone who isn't playing watch the game from a seat, or from
+// SeatOmniscient once the game is over or if its options allow it.
+func (g *Game) Spectate(id string, seat int) string {
+	if !g.Options.AllowSpectators {
+		return "Attempting to spectate a game that doesn't allow spectators."
+	}
+	if g.State == StateNotStarted {
+		return "Attempting to spectate a game that hasn't started."
+	}
+	if g.GetPlayerByGoogleID(id) != nil {
+		return "Attempting to spectate a game as one of its players."
+	}
+	if seat == SeatOmniscient {
+		if !GameStateIsFinished(g.State) && !g.Options.OmniscientSpectators {
+			return "Attempting to see every hand in a game that doesn't allow it."
+		}
+	} else if seat < 0 || seat >= len(g.Players) {
+		return "Attempting to spectate from a seat that doesn't exist."
+	}
+
+	if g.spectators == nil {
+		g.spectators = make(map[string]int)
+	}
+	g.spectators[id] = seat
+	return ""
+}
+
+func (g *Game) StopSpectating(id string) {
+	delete(g.spectators, id)
+}
+
+// SpectatorSeat returns the seat a spectator watches from, and whether they
+// are spectating at all.
+func (g *Game) SpectatorSeat(id string) (int, bool) {
+	seat, ok := g.spectators[id]
+	return seat, ok
+}
+
+func (g *Game) SetSpectators(spectators map[string]int) {
+	g.spectators = spectators
+}
+
+// RemovePlayer takes a player out of a game that hasn't started yet. If they
+// were the host, whoever is now in the first seat takes over.
+func (g *Game) RemovePlayer(id string) string {
+	if g.State != StateNotStarted {
+		return "Attempting to remove players after game has started."
+	}
+	index := g.playerIndex(id)
+	if index < 0 {
+		return "Attempting to remove a player who isn't in the game."
+	}
+
+	g.Players = append(g.Players[:index], g.Players[index+1:]...)
+	g.Table.NumPlayers--
+	g.Table.Turn--
+	if g.Host == id {
+		g.Host = ""
+		if len(g.Players) > 0 {
+			g.Host = g.Players[0].GoogleID
+		}
+	}
+	return ""
+}
+
+// MovePlayer puts a player in a different seat, shifting everyone between
+// their old seat and the new one over by one.
+func (g *Game) MovePlayer(id string, seat int) string {
+	if g.State != StateNotStarted {
+		return "Attempting to change seats after game has started."
+	}
+	index := g.playerIndex(id)
+	if index < 0 {
+		return "Attempting to move a player who isn't in the game."
+	}
+	if seat < 0 || seat >= len(g.Players) {
+		return "Attempting to move a player to a seat that doesn't exist."
+	}
+
+	player := g.Players[index]
+	g.Players = append(g.Players[:index], g.Players[index+1:]...)
+	g.Players = append(g.Players[:seat], append([]Player{player}, g.Players[seat:]...)...)
+	return ""
+}
+
+func (g *Game) playerIndex(id string) int {
+	for index := range g.Players {
+		if g.Players[index].GoogleID == id {
+			return index
+		}
+	}
+	return -1
+}
+
 func (g *Game) Start() string {
 	if g.State != StateNotStarted {
 		return "Attempting to start a game that has already been started."
@@ -87,6 +315,12 @@ func (g *Game) Start() string {
 		log.Fatal("Drawing card on empty deck!")
 	}
 
+	// a seeded deal decides who goes first as well as the deck
+	var seeded *rand.Rand
+	if g.Table.Seed != 0 {
+		seeded = g.Table.shuffle()
+	}
+
 	// create hands
 	for index := range g.Players {
 		g.Players[index].Initialize(cardsInHand[numPlayers])
@@ -99,16 +333,40 @@ func (g *Game) Start() string {
 	}
 
 	// let's do it
-	g.Table.CurrentPlayerIndex = rand.Intn(numPlayers)
+	if seeded != nil {
+		g.Table.StartingPlayer = seeded.Intn(numPlayers)
+	} else if !g.Table.StartingPlayerChosen || g.Table.StartingPlayer >= numPlayers {
+		g.Table.StartingPlayer = rand.Intn(numPlayers)
+	}
+	g.Table.CurrentPlayerIndex = g.Table.StartingPlayer
 	g.State = StateStarted
 	g.StartTime = time.Now().Unix()
 	g.LastUpdateTime = g.StartTime
 	g.Table.Turn++
+	g.Table.TurnStartTime = g.StartTime
+	if g.Options.TimeBank > 0 {
+		g.Table.Clocks = make([]int64, numPlayers)
+		for index := range g.Table.Clocks {
+			g.Table.Clocks[index] = g.Options.TimeBank
+		}
+	}
 	g.SendCurrentPlayerNotification()
 
 	return ""
 }
 
+// TurnsTaken counts the turns players have actually taken, leaving out
+// announcements and votes.
+func (g *Game) TurnsTaken() int {
+	turns := 0
+	for _, a := range g.Table.Actions {
+		if a.Type == ActionPlay || a.Type == ActionDiscard || a.Type == ActionHint || a.Type == ActionSkip {
+			turns++
+		}
+	}
+	return turns
+}
+
 // Wrapper in case we ever need a global time stamp to coordinate amongst distributed servers
 func getCurrentTime() int64 {
 	return time.Now().Unix()
@@ -119,15 +377,20 @@ func (g *Game) ProcessAnnouncement(mp *Message) string {
 	if g.State != StateStarted {
 		return "Attempting to process an announcement for a non-ongoing game."
 	}
+	if g.Options.DisableChat {
+		return "Attempting to make an announcement in a game without table talk."
+	}
 	p := g.GetPlayerByGoogleID(m.Player)
 	if p == nil {
 		return "Attempting to process an announcement for a nonexistent player."
 	}
-
-	// TODO: protect against code injection
+	announcement, err := SanitizeChat(m.Announcement)
+	if err != "" {
+		return err
+	}
 
 	// make announcement:
-	p.LastMove = ": " + m.Announcement
+	g.recordAction(p, Action{Type: ActionAnnounce, Text: announcement})
 	g.LastUpdateTime = getCurrentTime()
 
 	// success:
@@ -164,7 +427,7 @@ func (g *Game) ProcessMove(mp *Message) string {
 		if g.Table.PlayCard(card) {
 			// play was successful!
 			mp.Result = ResultPlay
-			if card.Number == 5 {
+			if g.Table.IsPileComplete(card.Color) {
 				g.Table.HintsLeft++
 				if g.Table.HintsLeft > MaxHints {
 					g.Table.HintsLeft = MaxHints
@@ -173,7 +436,7 @@ func (g *Game) ProcessMove(mp *Message) string {
 			if g.Table.ArePilesComplete() {
 				g.State = StatePerfect
 			}
-			p.LastMove = "played " + card.Color + " " + strconv.Itoa(card.Number)
+			g.recordAction(p, Action{Type: ActionPlay, Card: actionCard(card), Result: ResultPlay})
 		} else {
 			// play was unsuccessful :(
 			mp.Result = ResultBomb
@@ -182,7 +445,7 @@ func (g *Game) ProcessMove(mp *Message) string {
 				g.State = StateBombedOut
 			}
 			g.Table.Discard = append(g.Table.Discard, card)
-			p.LastMove = "bombed " + card.Color + " " + strconv.Itoa(card.Number)
+			g.recordAction(p, Action{Type: ActionPlay, Card: actionCard(card), Result: ResultBomb})
 		}
 	} else if m.MoveType == MoveDiscard {
 		card, err := p.RemoveCard(m.CardIndex)
@@ -195,7 +458,7 @@ func (g *Game) ProcessMove(mp *Message) string {
 		if g.Table.HintsLeft > MaxHints {
 			g.Table.HintsLeft = MaxHints
 		}
-		p.LastMove = "discarded " + card.Color + " " + strconv.Itoa(card.Number)
+		g.recordAction(p, Action{Type: ActionDiscard, Card: actionCard(card)})
 	} else if m.MoveType == MoveHint {
 		if g.Table.HintsLeft <= 0 {
 			return "Attempting to hint with no hints remaining."
@@ -204,22 +467,22 @@ func (g *Game) ProcessMove(mp *Message) string {
 		if hintReceiver == nil {
 			return "Attempting to give hint to a nonexistent player."
 		}
-		cardsHinted, err := hintReceiver.ReceiveHint(m.CardIndex, m.HintInfoType, m.HintColor, g.Mode)
+		cardsHinted, err := hintReceiver.ReceiveHint(m.CardIndex, m.HintInfoType, m.HintColor, m.HintNumber, g.Variant())
 		if err != "" {
 			return "Error giving hint: " + err
 		}
 		cardsModified = append(cardsModified, cardsHinted...)
 		g.Table.HintsLeft--
 
-		p.LastMove = "➡ " + hintReceiver.Name + " "
-		hintedCard := hintReceiver.GetCardByID(cardsModified[0])
+		hintedCard, _ := hintReceiver.GetCard(m.CardIndex)
+		hintColor, hintNumber := g.Variant().HintValue(hintedCard, m.HintColor, m.HintNumber)
+		action := Action{Type: ActionHint, HintPlayer: hintReceiver.GoogleID, HintInfoType: m.HintInfoType, CardsTouched: cardsHinted}
 		if m.HintInfoType == HintNumber {
-			p.LastMove += strconv.Itoa(hintedCard.Number) + "s"
-		} else if (g.Mode == ModeWildcard || g.Mode == ModeHard) && hintedCard.Color == "rainbow" {
-			p.LastMove += mp.HintColor + "s"
+			action.HintNumber = hintNumber
 		} else {
-			p.LastMove += hintedCard.Color + "s"
+			action.HintColor = hintColor
 		}
+		g.recordAction(p, action)
 
 	} else {
 		return "Attempting to process unknown move type."
@@ -236,6 +499,90 @@ func (g *Game) ProcessMove(mp *Message) string {
 		}
 	}
 
+	// playing on means nobody took up a proposal to end the game
+	g.Table.EndVotes = nil
+	g.endTurn(cardsModified)
+	return ""
+}
+
+// SkipTurn passes the current player's turn without them doing anything, for
+// players who have let a correspondence game's turn deadline go by.
+func (g *Game) SkipTurn() string {
+	if g.State != StateStarted {
+		return "Attempting to skip a turn in a non-ongoing game."
+	}
+	g.recordAction(&g.Players[g.Table.CurrentPlayerIndex], Action{Type: ActionSkip})
+	skips := g.Table.ConsecutiveSkips + 1
+	if skips >= len(g.Players) {
+		// a whole round has gone by without anyone moving
+		g.State = StateExpired
+		return ""
+	}
+	g.endTurn(make([]int, 0))
+	g.Table.ConsecutiveSkips = skips
+	return ""
+}
+
+// VoteToEnd records a player's vote on ending the game early. The first vote
+// in favor proposes it; once enough players agree the game is terminated, and
+// once too many disagree for that to happen the proposal is dropped. A
+// proposal also lapses as soon as anyone makes a move.
+func (g *Game) VoteToEnd(playerid string, inFavor bool) string {
+	if g.State != StateStarted {
+		return "Attempting to vote on ending a non-ongoing game."
+	}
+	p := g.GetPlayerByGoogleID(playerid)
+	if p == nil {
+		return "Attempting to vote on ending a game with a nonexistent player."
+	}
+	if g.Table.EndVotes == nil {
+		if !inFavor {
+			return "Attempting to vote against ending a game nobody has proposed ending."
+		}
+		g.Table.EndVotes = make(map[string]bool)
+		g.recordAction(p, Action{Type: ActionProposeEnd})
+	} else if inFavor {
+		g.recordAction(p, Action{Type: ActionVoteEnd})
+	} else {
+		g.recordAction(p, Action{Type: ActionVoteContinue})
+	}
+	g.Table.EndVotes[playerid] = inFavor
+	g.LastUpdateTime = getCurrentTime()
+
+	votesFor, votesAgainst := 0, 0
+	for _, vote := range g.Table.EndVotes {
+		if vote {
+			votesFor++
+		} else {
+			votesAgainst++
+		}
+	}
+	needed := len(g.Players)/2 + 1
+	if g.Options.UnanimousTermination {
+		needed = len(g.Players)
+	}
+	if votesFor >= needed {
+		g.State = StateTerminated
+	} else if len(g.Players)-votesAgainst < needed {
+		g.Table.EndVotes = nil
+	}
+	return ""
+}
+
+// endTurn passes play to the next player once the current one has moved.
+func (g *Game) endTurn(cardsModified []int) {
+	now := getCurrentTime()
+	if g.Table.Clocks != nil {
+		remaining := g.Table.Clocks[g.Table.CurrentPlayerIndex] - (now - g.Table.TurnStartTime)
+		if remaining < 0 {
+			remaining = 0
+		}
+		g.Table.Clocks[g.Table.CurrentPlayerIndex] = remaining + g.Options.TimeIncrement
+	}
+	g.Table.TurnStartTime = now
+	g.Table.ReminderSent = false
+	g.Table.ConsecutiveSkips = 0
+
 	g.Table.Turn++
 	if g.Table.TurnsLeft > 0 {
 		g.Table.TurnsLeft--
@@ -253,21 +600,48 @@ func (g *Game) ProcessMove(mp *Message) string {
 	g.SendCurrentPlayerNotification()
 	g.Table.CardsLastModified = cardsModified
 
-	if g.State == StateStarted && !g.AnyPlayableCards() {
+	g.CurrentScore = g.Table.Score()
+	g.Table.HighestPossibleScore = g.GetHighestPossibleScore()
+
+	if g.State == StateStarted && g.Table.HighestPossibleScore <= g.CurrentScore {
 		g.State = StateNoPlays
 	}
+}
 
-	g.CurrentScore = g.Table.Score()
-	g.Table.HighestPossibleScore = g.GetHighestPossibleScore()
+// actionCard keeps only what everyone at the table saw of a played or
+// discarded card.
+func actionCard(c Card) *Card {
+	return &Card{ID: c.ID, Color: c.Color, Number: c.Number}
+}
 
-	return ""
+func (g *Game) CreateState(playerid string, empathy bool) Game {
+	gCopy := g.createState(g.GetPlayerByGoogleID(playerid), empathy)
+	if playerid != g.Host {
+		gCopy.InviteCode = ""
+	}
+	gCopy.AvailableMoves = g.LegalMoves(playerid)
+	return gCopy
 }
 
-func (g *Game) CreateState(playerid string) Game {
-	p := g.GetPlayerByGoogleID(playerid)
+// CreateSpectatorState shows a spectator the game as the player in their seat
+// sees it, or with every hand visible if they watch from SeatOmniscient.
+func (g *Game) CreateSpectatorState(seat int, empathy bool) Game {
+	var viewer *Player
+	if seat >= 0 && seat < len(g.Players) {
+		viewer = &g.Players[seat]
+	}
+	gCopy := g.createState(viewer, empathy && viewer != nil)
+	gCopy.InviteCode = ""
+	return gCopy
+}
 
+// createState copies the game with everything the viewer shouldn't know
+// hidden. A nil viewer sees every hand.
+func (g *Game) createState(p *Player, empathy bool) Game {
 	gCopy := Game{}
 	gCopy = *g
+	gCopy.SpectatorCount = len(g.spectators)
+	gCopy.spectators = nil
 
 	// clear the Deck (could be used to determine your hand)
 
@@ -277,25 +651,213 @@ func (g *Game) CreateState(playerid string) Game {
 
 	gCopy.Table.CardsLeft = len(gCopy.Table.Deck)
 	gCopy.Table.Deck = make([]Card, 0)
+	gCopy.Table.Seed = 0
+	if g.Table.Clocks != nil {
+		now := getCurrentTime()
+		gCopy.Table.Clocks = make([]int64, len(g.Table.Clocks))
+		for index := range g.Table.Clocks {
+			gCopy.Table.Clocks[index] = g.TimeRemaining(index, now)
+		}
+	}
+	gCopy.Table.Discard = g.Table.annotateCards(g.Table.Discard)
+	gCopy.Table.PileCards = g.Table.annotateCards(g.Table.PileCards)
+
+	var possibilities [][]CardCount
+	if empathy {
+		possibilities = g.Empathy(p.GoogleID)
+	}
 
 	// clear your hand, except for revealed info
 	newPlayers := make([]Player, len(g.Players))
 	for playerIndex, player := range gCopy.Players {
-		if p.GoogleID == player.GoogleID {
-			newHand := make([]Card, len(player.Cards))
-			for cardIndex, card := range player.Cards {
+		newHand := make([]Card, len(player.Cards))
+		for cardIndex, card := range player.Cards {
+			if p != nil && p.GoogleID == player.GoogleID {
 				card.Color = ""
 				card.Number = 0
-				newHand[cardIndex] = card
+				if len(card.PossibleColors) == 1 && len(card.PossibleNumbers) == 1 {
+					card.Status = g.Table.CardStatus(Card{Color: card.PossibleColors[0], Number: card.PossibleNumbers[0]})
+				}
+				if empathy {
+					card.Empathy = possibilities[cardIndex]
+				}
+			} else {
+				card.Status = g.Table.CardStatus(card)
 			}
-			player.Cards = newHand
+			newHand[cardIndex] = card
 		}
+		player.Cards = newHand
 		newPlayers[playerIndex] = player
 	}
 	gCopy.Players = newPlayers
 	return gCopy
 }
 
+// Empathy works out, for each card in the player's hand, which identities it
+// could still have given the hints it has received and every card the player
+// can see: the discard pile, the played piles and everyone else's hands.
+func (g *Game) Empathy(playerid string) [][]CardCount {
+	unseen := make(map[string][]int)
+	for _, color := range g.Table.Colors {
+		unseen[color] = make([]int, NumberStart+1)
+		for _, number := range g.Variant().Numbers() {
+			unseen[color][number] = g.Table.CopiesOfCard(color, number)
+		}
+	}
+	seen := make([]Card, 0, g.Table.MaxCards())
+	seen = append(seen, g.Table.Discard...)
+	seen = append(seen, g.Table.PileCards...)
+	for _, player := range g.Players {
+		if player.GoogleID != playerid {
+			seen = append(seen, player.Cards...)
+		}
+	}
+	for _, card := range seen {
+		if counts, ok := unseen[card.Color]; ok && counts[card.Number] > 0 {
+			counts[card.Number]--
+		}
+	}
+
+	p := g.GetPlayerByGoogleID(playerid)
+	if p == nil {
+		return nil
+	}
+	possibilities := make([][]CardCount, len(p.Cards))
+	for index, card := range p.Cards {
+		if card.PossibleColors == nil {
+			card.ResetPossibilities(g.Variant())
+		}
+		possibilities[index] = make([]CardCount, 0)
+		for _, color := range card.PossibleColors {
+			counts, ok := unseen[color]
+			if !ok {
+				continue
+			}
+			for _, number := range card.PossibleNumbers {
+				if count := counts[number]; count > 0 {
+					possibilities[index] = append(possibilities[index], CardCount{Color: color, Number: number, Count: count})
+				}
+			}
+		}
+	}
+	return possibilities
+}
+
+// LegalMoves lists every move the given player could make right now. Only the
+// current player has any; hints are listed once per distinct value, along with
+// the IDs of the cards they would touch.
+func (g *Game) LegalMoves(playerid string) []LegalMove {
+	moves := make([]LegalMove, 0)
+	if g.State != StateStarted || g.Players[g.Table.CurrentPlayerIndex].GoogleID != playerid {
+		return moves
+	}
+	p := g.GetPlayerByGoogleID(playerid)
+
+	for index := range p.Cards {
+		moves = append(moves, LegalMove{MoveType: MovePlay, CardIndex: index})
+	}
+	for index := range p.Cards {
+		moves = append(moves, LegalMove{MoveType: MoveDiscard, CardIndex: index})
+	}
+
+	if g.Table.HintsLeft <= 0 {
+		return moves
+	}
+	for _, receiver := range g.Players {
+		if receiver.GoogleID == playerid {
+			continue
+		}
+		// numbers that can't be named, like START, are turned down by ReceiveHint
+		for _, number := range g.Variant().Numbers() {
+			moves = g.appendHint(moves, receiver, HintNumber, number, "")
+		}
+		for _, color := range g.Variant().HintColors() {
+			moves = g.appendHint(moves, receiver, HintColor, 0, color)
+		}
+	}
+	return moves
+}
+
+func (g *Game) appendHint(moves []LegalMove, receiver Player, infoType int, number int, color string) []LegalMove {
+	// point at the first card the hint would touch, if there is one
+	index := -1
+	for i, card := range receiver.Cards {
+		if (infoType == HintNumber && g.Variant().TouchedByNumber(card, number)) ||
+			(infoType == HintColor && g.Variant().TouchedByColor(card, color)) {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return moves
+	}
+
+	// hint a copy of the hand so the real one is left untouched
+	receiver.Cards = append([]Card(nil), receiver.Cards...)
+	touched, err := receiver.ReceiveHint(index, infoType, color, number, g.Variant())
+	if err != "" {
+		return moves
+	}
+	return append(moves, LegalMove{MoveType: MoveHint, CardIndex: index, HintPlayer: receiver.GoogleID,
+		HintInfoType: infoType, HintNumber: number, HintColor: color, CardsTouched: touched})
+}
+
+// TimeRemaining is how much time is left on a player's clock, counting the
+// turn in progress if it is theirs.
+func (g *Game) TimeRemaining(index int, now int64) int64 {
+	remaining := g.Table.Clocks[index]
+	if index == g.Table.CurrentPlayerIndex && g.State == StateStarted {
+		remaining -= now - g.Table.TurnStartTime
+	}
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// TurnStarted is when the current turn began. Games started before this was
+// tracked fall back on the last time anything happened in them.
+func (g *Game) TurnStarted() int64 {
+	if g.Table.TurnStartTime == 0 {
+		return g.LastUpdateTime
+	}
+	return g.Table.TurnStartTime
+}
+
+func (g *Game) DeadlinePassed(now int64) bool {
+	return g.State == StateStarted && g.Options.TurnDeadline > 0 && now-g.TurnStarted() >= g.Options.TurnDeadline
+}
+
+// NeedsReminder says whether the current player is halfway to the turn
+// deadline without having been reminded yet.
+func (g *Game) NeedsReminder(now int64) bool {
+	return g.State == StateStarted && g.Options.TurnDeadline > 0 && !g.Table.ReminderSent &&
+		now-g.TurnStarted() >= g.Options.TurnDeadline/2
+}
+
+func (g *Game) ClockExpired(now int64) bool {
+	return g.State == StateStarted && g.Table.Clocks != nil && g.TimeRemaining(g.Table.CurrentPlayerIndex, now) <= 0
+}
+
+// TimeoutMove is the move made for a player who runs out of time in a game
+// that doesn't end when that happens: discarding their chop, the oldest card
+// they haven't been told anything about.
+func (g *Game) TimeoutMove() Message {
+	p := g.Players[g.Table.CurrentPlayerIndex]
+	chop := 0
+	for index, card := range p.Cards {
+		if card.KnownColor == "" && card.KnownNumber == 0 {
+			chop = index
+			break
+		}
+	}
+	return Message{Game: g.ID, Player: p.GoogleID, MoveType: MoveDiscard, CardIndex: chop}
+}
+
+func (g *Game) Variant() *Variant {
+	return GetVariant(g.Mode)
+}
+
 func (g *Game) GetPlayerByGoogleID(id string) *Player {
 	var p *Player
 	if g.Players == nil {
@@ -309,13 +871,19 @@ func (g *Game) GetPlayerByGoogleID(id string) *Player {
 	return p
 }
 
+// SendCurrentPlayerNotification tells the current player it's their turn. It
+// is called with the server locked, so the push itself goes out on its own
+// goroutine rather than holding everyone else up.
 func (g *Game) SendCurrentPlayerNotification() {
 	token := g.GetPlayerByGoogleID(g.Players[g.Table.CurrentPlayerIndex].GoogleID).PushToken
 	fmt.Printf("Sending notification to token %s", token)
 	if token == "" {
 		return
 	}
+	go sendPushNotification(token, g.ID)
+}
 
+func sendPushNotification(token string, gameId string) {
 	opts := []option.ClientOption{option.WithCredentialsJSON([]byte(WebPushKey))}
 	app, firebaseErr := firebase.NewApp(context.Background(), nil, opts...)
 	if firebaseErr != nil {
@@ -331,7 +899,7 @@ func (g *Game) SendCurrentPlayerNotification() {
 	}
 
 	response, sendErr := fcmClient.Send(context.Background(), &messaging.Message{
-		Data:  map[string]string{"game": g.ID},
+		Data:  map[string]string{"game": gameId},
 		Token: token,
 	})
 
@@ -351,69 +919,40 @@ func (g *Game) GetPlayerListAsString() string {
 	return playerString[:-len(playerString)-1]
 }
 
-func (g *Game) AnyPlayableCards() bool {
-	if g.Table.Turn < 15 {
-		// not possible for there to be no playable cards yet
-		return true
-	}
-
-	for i := range g.Players {
-		if g.Table.TurnsLeft != -1 && i >= g.Table.TurnsLeft {
-			break
-		}
-		p := g.Players[(i+g.Table.CurrentPlayerIndex)%len(g.Players)]
-		for _, c := range p.Cards {
-			if g.Table.CardPlayableOnPile(c) > -1 {
-				return true
-			}
-		}
-	}
-
-	for _, c := range g.Table.Deck {
-		if g.Table.CardPlayableOnPile(c) > -1 {
-			return true
-		}
-	}
-
-	return false
-}
-
+// GetHighestPossibleScore caps each pile below its first card that has had
+// every copy discarded, then limits the total by the plays left in the game:
+// one per turn, and once the deck is empty only from players who still have a
+// turn and are holding a card that could score.
 func (g *Game) GetHighestPossibleScore() int {
 	score := g.Table.Score()
-	cards := make([]Card, 0, g.Table.MaxCards())
-	cards = append(cards, g.Table.Deck...)
-	for _, player := range g.Players {
-		cards = append(cards, player.Cards...)
-	}
 
-	piles := make([]int, len(g.Table.Piles))
-	for i, value := range g.Table.Piles {
-		piles[i] = value
+	reachable := 0
+	for index := range g.Table.Piles {
+		reachable += g.Table.MaxPileValue(index) - g.Table.Piles[index]
 	}
 
 	turnsLeft := len(g.Table.Deck) + len(g.Players)
 	if g.Table.TurnsLeft > -1 && turnsLeft > g.Table.TurnsLeft {
 		turnsLeft = g.Table.TurnsLeft
 	}
-
-MainLoop:
-	for turnsLeft > 0 {
-		for _, c := range cards {
-			pile := g.Table.CardPlayableOnCustomPile(c, piles)
-			if pile > -1 {
-				piles[pile]++
-				score++
-				turnsLeft--
-				if turnsLeft == 0 {
-					break MainLoop
+	if len(g.Table.Deck) == 0 && g.Table.TurnsLeft > -1 {
+		turnsLeft = 0
+		for i := 0; i < g.Table.TurnsLeft && i < len(g.Players); i++ {
+			p := g.Players[(i+g.Table.CurrentPlayerIndex)%len(g.Players)]
+			for _, c := range p.Cards {
+				status := g.Table.CardStatus(c)
+				if status == CardStatusNeeded || status == CardStatusCritical {
+					turnsLeft++
+					break
 				}
-				continue MainLoop
 			}
 		}
-		break
 	}
 
-	return score
+	if reachable > turnsLeft {
+		reachable = turnsLeft
+	}
+	return score + reachable
 }
 
 func (g *Game) IsDeleteable() bool {
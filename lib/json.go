@@ -23,9 +23,41 @@ type Message struct {
 	MaxHints      int
 	LastTurn      int
 	UpdateTime    int64
+	ChatTime      int64
 	IgnoreTime    bool
 	SighButton    bool
 	Announcement  string
+	Empathy       bool
+	Options       GameOptions
+	Vote          bool
+	TargetPlayer  string
+	Seat          int
+	InviteCode    string
+	Text          string
+	Before        int64
+	Language      string
+	Rotate        bool
+	Series        string
+	SeriesLength  int
+	OpenSeries    bool
+	Challenge     bool
+	ChallengeDate string
+	NumPlayers    int
+	Tournament    string
+	Name          string
+	Rounds        int
+	Team          string
+	TeamPlayers   []string
+}
+
+type LegalMove struct {
+	MoveType     int
+	CardIndex    int
+	HintPlayer   string
+	HintInfoType int
+	HintNumber   int
+	HintColor    string
+	CardsTouched []int
 }
 
 type MinimalGame struct {
@@ -48,9 +80,10 @@ type StatsMessage struct {
 }
 
 type PlayerStats struct {
-	ID    string
-	Name  string
-	Stats [][]StatLog
+	ID      string
+	Name    string
+	Stats   [][]StatLog
+	Ratings []Rating
 }
 
 type StatLog struct {
@@ -63,6 +96,9 @@ type StatLog struct {
 	BombsLosses   int64
 	TurnsLosses   int64
 	NoPlaysLosses int64
+	TimeoutLosses int64
+	ExpiredLosses int64
+	Terminated    int64
 	TurnTime      int64
 	GameTime      int64
 	StartedGames  int64
@@ -71,9 +107,9 @@ type StatLog struct {
 }
 
 func CreateEmptyStatsArray() [][]StatLog {
-	stats := make([][]StatLog, Modes+1)
+	stats := make([][]StatLog, MaxVariantID()+1)
 
-	for i := 0; i <= Modes; i++ {
+	for i := range stats {
 		stats[i] = make([]StatLog, MaxPlayers+1)
 		for j := 0; j <= MaxPlayers; j++ {
 			stats[i][j].Scores = CreateSingleStatsArray()
@@ -83,7 +119,7 @@ func CreateEmptyStatsArray() [][]StatLog {
 }
 
 func CreateSingleStatsArray() []int {
-	return make([]int, MaxScoreAllModes+1)
+	return make([]int, MaxScoreAllVariants()+1)
 }
 
 func EncodeList(gl GamesList) (string, string) {
@@ -95,6 +131,69 @@ func EncodeList(gl GamesList) (string, string) {
 	return string(b), ""
 }
 
+func EncodeVariants(variants []*Variant) (string, string) {
+	b, err := json.Marshal(variants)
+	if err != nil {
+		return "", "Error encoding variants to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
+func EncodeJobs(jobs []JobStatus) (string, string) {
+	b, err := json.Marshal(jobs)
+	if err != nil {
+		return "", "Error encoding job status to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
+func EncodeChat(messages []ChatMessage) (string, string) {
+	b, err := json.Marshal(messages)
+	if err != nil {
+		return "", "Error encoding chat to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
+func EncodeSeries(series Series) (string, string) {
+	b, err := json.Marshal(series)
+	if err != nil {
+		return "", "Error encoding series to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
+func EncodeChallengeBoard(board ChallengeBoard) (string, string) {
+	b, err := json.Marshal(board)
+	if err != nil {
+		return "", "Error encoding challenge leaderboard to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
+func EncodeTournament(t Tournament) (string, string) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", "Error encoding tournament to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
+func EncodeRatings(ratings []Rating) (string, string) {
+	b, err := json.Marshal(ratings)
+	if err != nil {
+		return "", "Error encoding ratings to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
 func EncodeGame(g Game) (string, string) {
 	b, err := json.Marshal(g)
 	if err != nil {
@@ -124,6 +223,29 @@ func EncodeStatsMessage(sm StatsMessage) (string, string) {
 	return string(b), ""
 }
 
+func DecodeOptions(s string) (GameOptions, string) {
+	if s == "" {
+		return GameOptions{}, ""
+	}
+	b := []byte(s)
+	var options GameOptions
+	err := json.Unmarshal(b, &options)
+	if err != nil {
+		return GameOptions{}, "Error decoding options from JSON string.\nDecoding string: " + s + "\nError: " + err.Error()
+	}
+
+	return options, ""
+}
+
+func EncodeOptions(options GameOptions) (string, string) {
+	b, err := json.Marshal(options)
+	if err != nil {
+		return "", "Error encoding options to JSON string: " + err.Error()
+	}
+
+	return string(b), ""
+}
+
 func DecodeTable(s string) (Table, string) {
 	if s == "" {
 		return Table{}, ""
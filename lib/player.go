@@ -12,40 +12,86 @@ func (p *Player) Initialize(maxCards int) {
 	p.Cards = make([]Card, 0, maxCards)
 }
 
-func (p *Player) ReceiveHint(i int, infoType int, hintColor string, mode int) ([]int, string) {
+func (p *Player) ReceiveHint(i int, infoType int, hintColor string, hintNumber int, v *Variant) ([]int, string) {
 	var changedCards []int
 	card, err := p.GetCard(i)
 	if err != "" {
 		return changedCards, "Error retrieving card from player's hand: " + err
 	}
-	number := card.Number
-	color := card.Color
-	if color == "rainbow" {
-		color = hintColor
+	color, number := v.HintValue(card, hintColor, hintNumber)
+	if infoType == HintColor && !containsColor(v.HintColors(), color) {
+		return changedCards, "Attempting to give a hint for a color that can't be hinted: " + color
+	}
+	if infoType == HintNumber && (number < 1 || number > v.MaxNumber(card.Color)) {
+		return changedCards, "Attempting to give a hint for a number that doesn't exist."
+	}
+	if !p.anyTouched(infoType, color, number, v) {
+		// possible when pointing at a card that this kind of hint doesn't touch
+		return changedCards, "Attempting to give a hint that touches no cards."
 	}
 	for index := range p.Cards {
+		c := &p.Cards[index]
+		if c.PossibleColors == nil {
+			// cards dealt before possibilities were tracked
+			c.ResetPossibilities(v)
+		}
 		addCard := false
-		if infoType == HintNumber && p.Cards[index].Number == number {
-			p.Cards[index].KnownNumber = number
-			addCard = true
-		} else if infoType == HintColor && p.Cards[index].Color == color {
-			p.Cards[index].KnownColor = color
+		if infoType == HintNumber && v.TouchedByNumber(*c, number) {
+			if v.GetSuit(c.Color).NumberHints == TouchOwn {
+				c.KnownNumber = number
+			} else if c.KnownNumber == 0 {
+				c.KnownNumber = number
+			} else if c.KnownNumber != number {
+				// touched by two different numbers, which only this suit allows
+				c.KnownNumber = KnownNumberAny
+			}
 			addCard = true
-		} else if infoType == HintColor && (mode == ModeWildcard || mode == ModeHard) && p.Cards[index].Color == "rainbow" {
-			if p.Cards[index].KnownColor == "" {
-				p.Cards[index].KnownColor = color
-			} else if p.Cards[index].KnownColor != color {
-				p.Cards[index].KnownColor = "rainbow"
+		} else if infoType == HintColor && v.TouchedByColor(*c, color) {
+			if v.GetSuit(c.Color).ColorHints == TouchOwn {
+				c.KnownColor = color
+			} else if c.KnownColor == "" {
+				c.KnownColor = color
+			} else if c.KnownColor != color {
+				// touched by two different colors, so it can only be this suit
+				c.KnownColor = c.Color
 			}
 			addCard = true
 		}
+
+		// untouched cards learn just as much as touched ones
+		if infoType == HintNumber {
+			c.narrowPossibilities(addCard, func(possible Card) bool { return v.TouchedByNumber(possible, number) })
+		} else {
+			c.narrowPossibilities(addCard, func(possible Card) bool { return v.TouchedByColor(possible, color) })
+		}
+
 		if addCard {
-			changedCards = append(changedCards, p.Cards[index].ID)
+			changedCards = append(changedCards, c.ID)
 		}
 	}
 	return changedCards, ""
 }
 
+func (p *Player) anyTouched(infoType int, color string, number int, v *Variant) bool {
+	for _, c := range p.Cards {
+		if infoType == HintNumber && v.TouchedByNumber(c, number) {
+			return true
+		} else if infoType == HintColor && v.TouchedByColor(c, color) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsColor(colors []string, color string) bool {
+	for _, c := range colors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Player) GetCard(i int) (Card, string) {
 	if i >= len(p.Cards) {
 		return Card{}, "Referenced a non-existent card in a player's hand."
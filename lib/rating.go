@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// A Rating is how well a player tends to do in one variant. Hanabi is
+// cooperative, so everyone at the table gains or loses the same amount,
+// depending on how the team's score compares with what was expected of it.
+type Rating struct {
+	Player string
+	Name   string
+	Mode   int
+	Rating float64
+	Games  int
+}
+
+// expectedFraction is the share of a perfect score a team is expected to get:
+// the average for the variant and player count, raised or lowered by how the
+// team's rating compares with a new player's.
+func (db *Database) expectedFraction(game *Game, teamRating float64) float64 {
+	row := db.dbRef.QueryRow(`select coalesce(avg(score), 0), count(*) from games
+		where mode=? and players=? and state not in (?, ?, ?, ?) and id!=?`,
+		game.Mode, len(game.Players), StateNotStarted, StateStarted, StateTerminated, StateExpired, game.ID)
+	var average float64
+	var count int
+	if err := row.Scan(&average, &count); err != nil {
+		log.Fatal(err)
+	}
+
+	expected := DefaultExpectedFraction
+	if count >= MinRatingSampleGames {
+		expected = average / float64(game.Variant().PerfectScore())
+	}
+	expected += (teamRating - InitialRating) / RatingSpread
+	if expected < 0 {
+		return 0
+	}
+	if expected > 1 {
+		return 1
+	}
+	return expected
+}
+
+func (db *Database) GetRating(playerId string, mode int) Rating {
+	rating := Rating{Player: playerId, Mode: mode, Rating: InitialRating}
+	row := db.dbRef.QueryRow(`select rating, games from ratings where player_id=? and mode=?`, playerId, mode)
+	switch err := row.Scan(&rating.Rating, &rating.Games); err {
+	case sql.ErrNoRows, nil:
+	default:
+		log.Fatal(err)
+	}
+	return rating
+}
+
+// updateRatings rates everyone in a game the first time it is saved as
+// finished. Games ended by vote or left to expire don't count.
+func (db *Database) updateRatings(game *Game) {
+	if !GameStateIsFinished(game.State) || game.State == StateTerminated || game.State == StateExpired || len(game.Players) == 0 {
+		return
+	}
+	row := db.dbRef.QueryRow(`select count(*) from rating_history where game_id=?`, game.ID)
+	var rated int
+	if err := row.Scan(&rated); err != nil {
+		log.Fatal(err)
+	}
+	if rated > 0 {
+		return
+	}
+
+	ratings := make([]Rating, len(game.Players))
+	teamRating := 0.0
+	for index, player := range game.Players {
+		ratings[index] = db.GetRating(player.GoogleID, game.Mode)
+		teamRating += ratings[index].Rating
+	}
+	teamRating /= float64(len(ratings))
+
+	actual := float64(game.CurrentScore) / float64(game.Variant().PerfectScore())
+	change := RatingK * (actual - db.expectedFraction(game, teamRating))
+
+	now := time.Now().Unix()
+	db.openTransaction()
+	for _, rating := range ratings {
+		db.execWithinTransaction(`insert into ratings (player_id, mode, rating, games) values (?, ?, ?, 1)
+			on conflict (player_id, mode) do update set rating=excluded.rating, games=games+1`,
+			rating.Player, rating.Mode, rating.Rating+change)
+		db.execWithinTransaction(`insert into rating_history (player_id, mode, game_id, rating_before, rating_after, time) values (?, ?, ?, ?, ?, ?)`,
+			rating.Player, rating.Mode, game.ID, rating.Rating, rating.Rating+change, now)
+	}
+	db.closeTransaction()
+}
+
+// GetRatingLeaderboard lists the best rated players in a variant, leaving out
+// anyone who hasn't played enough games for their rating to mean much.
+func (db *Database) GetRatingLeaderboard(mode int) []Rating {
+	rows, err := db.dbRef.Query(`select player_id, coalesce(players.name, ''), rating, games from ratings
+		left join players on players.id=player_id
+		where mode=? and games>=? order by rating desc limit ?`, mode, MinRatedGames, MaxLeaderboardSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	ratings := make([]Rating, 0)
+	for rows.Next() {
+		rating := Rating{Mode: mode}
+		if err = rows.Scan(&rating.Player, &rating.Name, &rating.Rating, &rating.Games); err != nil {
+			log.Fatal(err)
+		}
+		ratings = append(ratings, rating)
+	}
+	return ratings
+}
+
+// getAllRatings returns every player's ratings, for the stats message.
+func (db *Database) getAllRatings() map[string][]Rating {
+	rows, err := db.dbRef.Query(`select player_id, coalesce(players.name, ''), mode, rating, games from ratings
+		left join players on players.id=player_id order by player_id, mode`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	ratings := make(map[string][]Rating)
+	for rows.Next() {
+		var rating Rating
+		if err = rows.Scan(&rating.Player, &rating.Name, &rating.Mode, &rating.Rating, &rating.Games); err != nil {
+			log.Fatal(err)
+		}
+		ratings[rating.Player] = append(ratings[rating.Player], rating)
+	}
+	return ratings
+}
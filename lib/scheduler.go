@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// A Job is a maintenance task the server runs in the background every
+// Interval. Jobs with no interval are registered but never run.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() string
+}
+
+// JobStatus is what the admin endpoint reports about a job.
+type JobStatus struct {
+	Name            string
+	Enabled         bool
+	IntervalSeconds int64
+	Runs            int64
+	Failures        int64
+	LastRun         int64
+	LastDurationMs  int64
+	LastError       string
+	NextRun         int64
+}
+
+type Scheduler struct {
+	jobs   []Job
+	status []JobStatus
+	m      sync.Mutex
+}
+
+func (s *Scheduler) Register(name string, interval time.Duration, run func() string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.jobs = append(s.jobs, Job{Name: name, Interval: interval, Run: run})
+	s.status = append(s.status, JobStatus{Name: name, Enabled: interval > 0, IntervalSeconds: int64(interval / time.Second)})
+}
+
+func (s *Scheduler) Start() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	for index, job := range s.jobs {
+		if job.Interval <= 0 {
+			continue
+		}
+		s.status[index].NextRun = now.Add(job.Interval).Unix()
+		go s.loop(index, job)
+	}
+}
+
+func (s *Scheduler) loop(index int, job Job) {
+	for range time.Tick(job.Interval) {
+		s.runJob(index, job)
+	}
+}
+
+func (s *Scheduler) runJob(index int, job Job) {
+	started := time.Now()
+	err := job.Run()
+	finished := time.Now()
+	if err != "" {
+		log.Printf("Scheduled job '%s' failed. Error: %s\n", job.Name, err)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	status := &s.status[index]
+	status.Runs++
+	if err != "" {
+		status.Failures++
+	}
+	status.LastRun = started.Unix()
+	status.LastDurationMs = finished.Sub(started).Milliseconds()
+	status.LastError = err
+	status.NextRun = started.Add(job.Interval).Unix()
+}
+
+func (s *Scheduler) Status() []JobStatus {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]JobStatus(nil), s.status...)
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// A Series is a run of games played by the same group, such as a weekly
+// best-of-5, and how the group has done across them so far.
+type Series struct {
+	ID     string
+	Name   string
+	Host   string
+	Length int // games in the series, or 0 to keep going indefinitely
+	Games  []SeriesGame
+
+	GamesPlayed   int
+	TotalScore    int
+	AverageScore  float64
+	PerfectGames  int
+	BombsLosses   int
+	TurnsLosses   int
+	NoPlaysLosses int
+	TimeoutLosses int
+	ExpiredLosses int
+	Terminated    int
+	Complete      bool
+}
+
+type SeriesGame struct {
+	ID    string
+	Mode  int
+	State int
+	Score int
+}
+
+func (db *Database) CreateSeries(name string, host string, length int) (string, string) {
+	if length < 0 || length > MaxSeriesLength {
+		return "", "Attempting to create a series with an invalid number of games."
+	}
+	id, err := randomToken(GameIDBytes)
+	if err != "" {
+		return "", err
+	}
+	db.execQuery(`insert into series (id, name, host, length, time_created) values (?, ?, ?, ?, ?)`,
+		id, name, host, length, time.Now().Unix())
+	return id, ""
+}
+
+// GetSeries adds up every finished game in a series. Games ended by vote are
+// listed but left out of the scores, like they are in the overall stats.
+func (db *Database) GetSeries(id string) (Series, string) {
+	series := Series{ID: id}
+	row := db.dbRef.QueryRow(`select name, host, length from series where id=?`, id)
+	switch err := row.Scan(&series.Name, &series.Host, &series.Length); err {
+	case sql.ErrNoRows:
+		return Series{}, "Series not found: " + id
+	case nil:
+	default:
+		log.Fatal(err)
+	}
+
+	rows, err := db.dbRef.Query(`select id, mode, state, score from games where series_id=? order by time_created`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	series.Games = make([]SeriesGame, 0)
+	for rows.Next() {
+		var game SeriesGame
+		if err = rows.Scan(&game.ID, &game.Mode, &game.State, &game.Score); err != nil {
+			log.Fatal(err)
+		}
+		series.Games = append(series.Games, game)
+		series.addGame(game)
+	}
+
+	if series.GamesPlayed > 0 {
+		series.AverageScore = float64(series.TotalScore) / float64(series.GamesPlayed)
+	}
+	series.Complete = series.Length > 0 && series.GamesPlayed >= series.Length
+	return series, ""
+}
+
+func (series *Series) addGame(game SeriesGame) {
+	if !GameStateIsFinished(game.State) {
+		return
+	}
+	switch game.State {
+	case StateTerminated:
+		series.Terminated++
+		return
+	case StatePerfect:
+		series.PerfectGames++
+	case StateBombedOut:
+		series.BombsLosses++
+	case StateDeckEmpty:
+		series.TurnsLosses++
+	case StateNoPlays:
+		series.NoPlaysLosses++
+	case StateOutOfTime:
+		series.TimeoutLosses++
+	case StateExpired:
+		series.ExpiredLosses++
+	}
+	series.GamesPlayed++
+	series.TotalScore += game.Score
+}
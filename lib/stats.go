@@ -122,6 +122,14 @@ func (db *Database) CreateStatsMessage() StatsMessage {
 					sm.Players[id].Stats[mode][players].TurnsLosses += 1
 				} else if state == StateNoPlays {
 					sm.Players[id].Stats[mode][players].NoPlaysLosses += 1
+				} else if state == StateOutOfTime {
+					sm.Players[id].Stats[mode][players].TimeoutLosses += 1
+				} else if state == StateExpired {
+					sm.Players[id].Stats[mode][players].ExpiredLosses += 1
+				} else if state == StateTerminated {
+					// ended by vote, so the score says nothing about how well it went
+					sm.Players[id].Stats[mode][players].Terminated += 1
+					continue
 				}
 				sm.Players[id].Stats[mode][players].Scores[score] += 1
 			}
@@ -180,11 +188,21 @@ func (db *Database) CreateStatsMessage() StatsMessage {
 			if state == StateDeckEmpty {
 				sm.Stats[mode][players].TurnsLosses += 1
 			}
+			if state == StateOutOfTime {
+				sm.Stats[mode][players].TimeoutLosses += 1
+			}
+			if state == StateExpired {
+				sm.Stats[mode][players].ExpiredLosses += 1
+			}
+			if state == StateTerminated {
+				sm.Stats[mode][players].Terminated += 1
+				continue
+			}
 
 			//scoreList := scoreListFromString(scores)
 
 			if len(sm.Stats[mode][players].Scores) == 0 {
-				sm.Stats[mode][players].Scores = make([]int, PerfectScoreForMode(mode)+1)
+				sm.Stats[mode][players].Scores = make([]int, GetVariant(mode).PerfectScore()+1)
 			}
 			sm.Stats[mode][players].Scores[score] += 1
 
@@ -196,6 +214,13 @@ func (db *Database) CreateStatsMessage() StatsMessage {
 		}
 	}
 
+	for id, ratings := range db.getAllRatings() {
+		if player, ok := sm.Players[id]; ok {
+			player.Ratings = ratings
+			sm.Players[id] = player
+		}
+	}
+
 	return sm
 }
 
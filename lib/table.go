@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"encoding/binary"
 	"log"
 	"math/rand"
 )
@@ -11,6 +12,7 @@ type Table struct {
 	Deck              []Card
 	Discard           []Card
 	Piles             []int
+	PileDirections    []int
 	PileCards         []Card
 	CardsLastModified []int
 	Colors            []string
@@ -22,22 +24,32 @@ type Table struct {
 	HighestPossibleScore int
 	NumPlayers           int
 	Mode                 int
+	StartingPlayer       int   // seat of whoever moved first
+	StartingPlayerChosen bool  // StartingPlayer was picked before the game started, not at random
+	Seed                 int64 // deals every game with this seed the same way, or 0 for a random deal
+
+	Clocks           []int64 // seconds left for each player, in timed games
+	TurnStartTime    int64
+	ReminderSent     bool
+	ConsecutiveSkips int // turns skipped in a row for missing the deadline
+
+	EndVotes map[string]bool // each voter's GoogleID and whether they want the game ended early
+	Actions  []Action        // everything every player has done, oldest first
 }
 
 func (t *Table) Initialize(gameMode int) {
 	t.Mode = gameMode
 
 	// figure out how many cards are in the Deck
-	if gameMode == ModeNormal {
-		t.Colors = normalColors[:]
-	} else {
-		t.Colors = rainbowColors[:]
-	}
+	t.Colors = t.Variant().Colors()
 
 	maxCards := t.MaxCards()
 	t.PopulateDeck(maxCards)
 	t.Discard = make([]Card, 0, maxCards)
 	t.Piles = make([]int, len(t.Colors))
+	if t.Variant().UpOrDown {
+		t.PileDirections = make([]int, len(t.Colors))
+	}
 
 	t.BombsLeft = StartingBombs
 	t.HintsLeft = StartingHints
@@ -50,15 +62,14 @@ func (t *Table) Initialize(gameMode int) {
 func (t *Table) PopulateDeck(maxCards int) {
 	t.Deck = make([]Card, maxCards)
 	i := 0
-	for number, count := range numbers {
+	for _, number := range t.Variant().Numbers() {
 		for _, color := range t.Colors {
-			if (t.Mode == ModeHard || t.Mode == ModeRainbowLimited) && color == ColorRainbow && count > 0 {
-				count = 1
-			}
+			count := t.CopiesOfCard(color, number)
 			for j := 0; j < count; j++ {
 				t.Deck[i].ID = i
 				t.Deck[i].Color = color
 				t.Deck[i].Number = number
+				t.Deck[i].ResetPossibilities(t.Variant())
 				i++
 			}
 		}
@@ -68,11 +79,40 @@ func (t *Table) PopulateDeck(maxCards int) {
 	}
 }
 
+// shuffle puts a seeded deck in order, so it can be drawn from the top, and
+// returns the random source for anything else the seed decides.
+func (t *Table) shuffle() *rand.Rand {
+	r := rand.New(rand.NewSource(t.Seed))
+	r.Shuffle(len(t.Deck), func(i, j int) { t.Deck[i], t.Deck[j] = t.Deck[j], t.Deck[i] })
+	return r
+}
+
+// seedFromBytes turns the first 8 of the given bytes into a seed for
+// Table.Seed, which is never 0 since that means an unseeded deck.
+func seedFromBytes(b []byte) int64 {
+	seed := int64(binary.BigEndian.Uint64(b))
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
+
+func (t *Table) Variant() *Variant {
+	return GetVariant(t.Mode)
+}
+
+func (t *Table) CopiesOfCard(color string, number int) int {
+	return t.Variant().CopiesOfCard(color, number)
+}
+
 func (t *Table) DrawCard() Card {
 	if len(t.Deck) <= 0 {
 		log.Fatal("Attempting to draw card from empty deck!")
 	}
-	index := rand.Intn(len(t.Deck))
+	index := 0
+	if t.Seed == 0 {
+		index = rand.Intn(len(t.Deck))
+	}
 	card := t.Deck[index]
 	t.Deck = append(t.Deck[:index], t.Deck[index+1:]...)
 	return card
@@ -82,21 +122,47 @@ func (t *Table) PlayCard(c Card) bool {
 	pile := t.CardPlayableOnPile(c)
 	if pile > -1 {
 		t.Piles[pile]++
+		if t.PileDirections != nil {
+			t.PileDirections[pile] = nextPileDirection(t.PileDirections[pile], c.Number)
+		}
 		t.PileCards = append(t.PileCards, c)
 		return true
 	}
 	return false
 }
 
+func nextPileDirection(direction int, number int) int {
+	switch direction {
+	case PileUndecided:
+		if number == 1 {
+			return PileUp
+		} else if number == NumberStart {
+			return PileStarted
+		}
+		return PileDown
+	case PileStarted:
+		if number == 2 {
+			return PileUp
+		}
+		return PileDown
+	}
+	return direction
+}
+
 func (t *Table) ArePilesComplete() bool {
-	for _, count := range t.Piles {
-		if count != len(numbers)-1 {
+	for _, color := range t.Colors {
+		if !t.IsPileComplete(color) {
 			return false
 		}
 	}
 	return true
 }
 
+func (t *Table) IsPileComplete(color string) bool {
+	index := t.pileIndex(color)
+	return index > -1 && t.Piles[index] == t.Variant().MaxNumber(color)
+}
+
 func (t *Table) Score() int {
 	score := 0
 	for _, count := range t.Piles {
@@ -105,39 +171,162 @@ func (t *Table) Score() int {
 	return score
 }
 
+func (t *Table) pileIndex(color string) int {
+	for index := range t.Colors {
+		if t.Colors[index] == color {
+			return index
+		}
+	}
+	return -1
+}
+
+// pileSequences lists the orders in which the rest of a pile could still be
+// played, given how many cards it has and which way it is going. Ordinary
+// piles only go up, but an up-or-down pile can go either way until its first
+// card or two decide it: a 1 or a 5, or a START followed by a 2 or a 4.
+func pileSequences(count int, direction int, maxNumber int) [][]int {
+	up := func(from int) []int {
+		sequence := make([]int, 0, maxNumber)
+		for number := from; number <= maxNumber; number++ {
+			sequence = append(sequence, number)
+		}
+		return sequence
+	}
+	down := func(from int) []int {
+		sequence := make([]int, 0, maxNumber)
+		for number := from; number >= 1; number-- {
+			sequence = append(sequence, number)
+		}
+		return sequence
+	}
+
+	switch direction {
+	case PileUndecided:
+		return [][]int{up(1), down(maxNumber),
+			append([]int{NumberStart}, up(2)...), append([]int{NumberStart}, down(maxNumber-1)...)}
+	case PileStarted:
+		return [][]int{up(2), down(maxNumber - 1)}
+	case PileDown:
+		return [][]int{down(maxNumber - count)}
+	}
+	return [][]int{up(count + 1)}
+}
+
+func (t *Table) remainingSequences(index int) [][]int {
+	return pileSequences(t.Piles[index], pileDirection(t.PileDirections, index), t.Variant().MaxNumber(t.Colors[index]))
+}
+
+func pileDirection(directions []int, index int) int {
+	if index >= len(directions) {
+		// only up-or-down variants keep track of directions
+		return PileUp
+	}
+	return directions[index]
+}
+
 // returns pile index if playable, -1 if not
-func (t *Table) CardPlayableOnCustomPile(c Card, p []int) int {
+func (t *Table) CardPlayableOnCustomPile(c Card, p []int, d []int) int {
 	for index, count := range p {
 		if t.Colors[index] == c.Color {
-			if count+1 == c.Number {
-				return index
-			} else {
-				return -1
+			for _, sequence := range pileSequences(count, pileDirection(d, index), t.Variant().MaxNumber(c.Color)) {
+				if len(sequence) > 0 && sequence[0] == c.Number {
+					return index
+				}
 			}
+			return -1
 		}
 	}
 	return -1
 }
 
 func (t *Table) CardPlayableOnPile(c Card) int {
-	return t.CardPlayableOnCustomPile(c, t.Piles)
+	return t.CardPlayableOnCustomPile(c, t.Piles, t.PileDirections)
 }
 
-func (t *Table) MaxCards() int {
-	maxCards := 0
-	for _, count := range numbers {
-		maxCards += count * len(t.Colors)
+func (t *Table) DiscardedCopies(color string, number int) int {
+	discarded := 0
+	for _, c := range t.Discard {
+		if c.Color == color && c.Number == number {
+			discarded++
+		}
 	}
-	if t.Mode == ModeHard || t.Mode == ModeRainbowLimited {
-		maxCards -= 5
+	return discarded
+}
+
+func (t *Table) isLost(color string, number int) bool {
+	return t.DiscardedCopies(color, number) >= t.CopiesOfCard(color, number)
+}
+
+// CardStatus says whether a card still matters: trash if no way of finishing
+// its pile needs it, dead if every such way first runs into a card that has
+// had every copy discarded, and critical if it is the last copy left and
+// losing it would lower how high its pile can still go.
+func (t *Table) CardStatus(c Card) int {
+	index := t.pileIndex(c.Color)
+	if index == -1 {
+		return CardStatusUnknown
 	}
-	return maxCards
+
+	needed, reachable := false, false
+	for _, sequence := range t.remainingSequences(index) {
+		blocked := false
+		for _, number := range sequence {
+			blocked = blocked || t.isLost(c.Color, number)
+			if number == c.Number {
+				needed = true
+				reachable = reachable || !blocked
+				break
+			}
+		}
+	}
+
+	if !needed {
+		return CardStatusTrash
+	}
+	if !reachable {
+		return CardStatusDead
+	}
+	if t.CopiesOfCard(c.Color, c.Number)-t.DiscardedCopies(c.Color, c.Number) <= 1 &&
+		t.maxPileValueLosing(index, c.Number) < t.MaxPileValue(index) {
+		return CardStatusCritical
+	}
+	return CardStatusNeeded
+}
+
+// MaxPileValue is the highest a pile can still reach before running into a
+// card that has had every copy discarded.
+func (t *Table) MaxPileValue(index int) int {
+	return t.maxPileValueLosing(index, 0)
+}
+
+// maxPileValueLosing is MaxPileValue as if every copy of the given number had
+// been discarded as well; 0 loses nothing more.
+func (t *Table) maxPileValueLosing(index int, lost int) int {
+	best := 0
+	for _, sequence := range t.remainingSequences(index) {
+		reached := 0
+		for _, number := range sequence {
+			if number == lost || t.isLost(t.Colors[index], number) {
+				break
+			}
+			reached++
+		}
+		if reached > best {
+			best = reached
+		}
+	}
+	return t.Piles[index] + best
 }
 
-func PerfectScoreForMode(mode int) int {
-	highScore := 30
-	if mode == ModeNormal {
-		highScore = 25
+func (t *Table) annotateCards(cards []Card) []Card {
+	annotated := make([]Card, len(cards))
+	for index, c := range cards {
+		c.Status = t.CardStatus(c)
+		annotated[index] = c
 	}
-	return highScore
+	return annotated
+}
+
+func (t *Table) MaxCards() int {
+	return t.Variant().MaxCards()
 }
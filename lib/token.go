@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// randomToken returns a URL-safe string built from the given number of
+// random bytes, suitable for anything that must not be guessable.
+func randomToken(bytes int) (string, string) {
+	b := make([]byte, bytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "Error generating random token: " + err.Error()
+	}
+	return base64.RawURLEncoding.EncodeToString(b), ""
+}
+
+// NewGameID returns a random ID for a new game. It says nothing about the
+// game's name or when it was created, unlike the IDs older versions made.
+func NewGameID() (string, string) {
+	return randomToken(GameIDBytes)
+}
@@ -0,0 +1,307 @@
+package lib
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A Tournament is a set of registered teams who all play the same seeded deal
+// in each round, ranked by their combined results.
+type Tournament struct {
+	ID           string
+	Name         string
+	Mode         int
+	TeamSize     int // every team has this many players, so they're all dealt the same hands
+	Rounds       int
+	CurrentRound int // 0 until the first round starts
+	Options      GameOptions
+	Teams        []TournamentTeam
+	Games        []TournamentGame
+	Standings    []TournamentStanding
+}
+
+type TournamentTeam struct {
+	Name    string
+	Players []string // GoogleIDs, in seating order
+	Names   []string
+}
+
+type TournamentGame struct {
+	Round  int
+	Team   string
+	GameID string
+	State  int
+	Score  int
+	Turns  int
+	Bombs  int
+}
+
+// Standings rank teams by total score, then fewest turns, then fewest bombs.
+// Teams level on all three share a rank.
+type TournamentStanding struct {
+	Rank        int
+	Team        string
+	GamesPlayed int
+	TotalScore  int
+	Turns       int
+	Bombs       int
+}
+
+func (db *Database) CreateTournament(name string, mode int, teamSize int, rounds int, options GameOptions) (string, string) {
+	if GetVariant(mode) == nil {
+		return "", "Attempting to create a tournament with an unknown variant."
+	}
+	if teamSize < 2 || teamSize > MaxPlayers {
+		return "", "Attempting to create a tournament with an invalid team size."
+	}
+	if rounds < 1 || rounds > MaxTournamentRounds {
+		return "", "Attempting to create a tournament with an invalid number of rounds."
+	}
+	// check the options the same way every game in the tournament will
+	var example Game
+	if err := example.Initialize(false, false, false, mode, options); err != "" {
+		return "", err
+	}
+	encodedOptions, err := EncodeOptions(example.Options)
+	if err != "" {
+		return "", err
+	}
+	id, err := randomToken(GameIDBytes)
+	if err != "" {
+		return "", err
+	}
+	db.execQuery(`insert into tournaments (id, name, mode, team_size, rounds, current_round, options, time_created) values (?, ?, ?, ?, ?, 0, ?, ?)`,
+		id, name, mode, teamSize, rounds, encodedOptions, time.Now().Unix())
+	return id, ""
+}
+
+func (db *Database) RegisterTeam(tournamentId string, name string, players []string) string {
+	t, err := db.GetTournament(tournamentId)
+	if err != "" {
+		return err
+	}
+	if t.CurrentRound > 0 {
+		return "Attempting to register a team after the tournament has started."
+	}
+	if name == "" {
+		return "Attempting to register a team without a name."
+	}
+	if len(players) != t.TeamSize {
+		return "Attempting to register a team with " + strconv.Itoa(len(players)) + " players in a tournament for teams of " + strconv.Itoa(t.TeamSize) + "."
+	}
+	registered := make(map[string]bool)
+	for _, team := range t.Teams {
+		if team.Name == name {
+			return "Attempting to register a team whose name is taken."
+		}
+		for _, player := range team.Players {
+			registered[player] = true
+		}
+	}
+	for _, player := range players {
+		if registered[player] {
+			return "Attempting to register a player who is already on a team: " + player
+		}
+		if _, ok := db.GetPlayerName(player); !ok {
+			return "Attempting to register a player who has never signed in: " + player
+		}
+		registered[player] = true
+	}
+
+	db.openTransaction()
+	for seat, player := range players {
+		db.execWithinTransaction(`insert into tournament_players (tournament_id, team, player_id, seat) values (?, ?, ?, ?)`,
+			tournamentId, name, player, seat)
+	}
+	db.closeTransaction()
+	return ""
+}
+
+func (db *Database) GetPlayerName(id string) (string, bool) {
+	row := db.dbRef.QueryRow(`select name from players where id=?`, id)
+	var name string
+	switch err := row.Scan(&name); err {
+	case sql.ErrNoRows:
+		return "", false
+	case nil:
+		return name, true
+	default:
+		log.Fatal(err)
+	}
+	return "", false
+}
+
+// StartTournamentRound moves a tournament on to its next round and picks the
+// seed every team in that round is dealt from.
+func (db *Database) StartTournamentRound(tournamentId string) (int, int64, string) {
+	t, err := db.GetTournament(tournamentId)
+	if err != "" {
+		return 0, 0, err
+	}
+	if t.CurrentRound >= t.Rounds {
+		return 0, 0, "Attempting to start a round after the tournament's last one."
+	}
+	if len(t.Teams) == 0 {
+		return 0, 0, "Attempting to start a round of a tournament with no teams."
+	}
+
+	b := make([]byte, 8)
+	if _, randError := rand.Read(b); randError != nil {
+		return 0, 0, "Error generating round seed: " + randError.Error()
+	}
+	seed := seedFromBytes(b)
+
+	round := t.CurrentRound + 1
+	db.openTransaction()
+	db.execWithinTransaction(`update tournaments set current_round=? where id=?`, round, tournamentId)
+	db.execWithinTransaction(`insert into tournament_rounds (tournament_id, round, seed) values (?, ?, ?)`, tournamentId, round, seed)
+	db.closeTransaction()
+	return round, seed, ""
+}
+
+func (db *Database) AddTournamentGame(tournamentId string, round int, team string, gameId string) {
+	db.execQuery(`insert into tournament_games (tournament_id, round, team, game_id) values (?, ?, ?, ?)`,
+		tournamentId, round, team, gameId)
+}
+
+// NewTournamentGame sets up a team's game for a round, with the team already
+// seated and the round's deal.
+func NewTournamentGame(t Tournament, team TournamentTeam, round int, seed int64) (*Game, string) {
+	game := new(Game)
+	if err := game.Initialize(false, false, false, t.Mode, t.Options); err != "" {
+		return nil, err
+	}
+	game.Name = t.Name + " " + strconv.Itoa(round)
+	game.Host = team.Players[0]
+	for index, player := range team.Players {
+		if err := game.AddPlayer(player, team.Names[index]); err != "" {
+			return nil, err
+		}
+	}
+	game.Table.Seed = seed
+	return game, ""
+}
+
+// IsTournamentGame says whether a game was made for a round of a tournament,
+// whose teams can't change.
+func (db *Database) IsTournamentGame(gameId string) bool {
+	row := db.dbRef.QueryRow(`select count(*) from tournament_games where game_id=?`, gameId)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+func (db *Database) GetTournament(id string) (Tournament, string) {
+	t := Tournament{ID: id}
+	var encodedOptions string
+	row := db.dbRef.QueryRow(`select name, mode, team_size, rounds, current_round, options from tournaments where id=?`, id)
+	switch err := row.Scan(&t.Name, &t.Mode, &t.TeamSize, &t.Rounds, &t.CurrentRound, &encodedOptions); err {
+	case sql.ErrNoRows:
+		return Tournament{}, "Tournament not found: " + id
+	case nil:
+	default:
+		log.Fatal(err)
+	}
+	options, err := DecodeOptions(encodedOptions)
+	if err != "" {
+		log.Fatal(err)
+	}
+	t.Options = options
+
+	t.Teams = db.getTournamentTeams(id)
+	t.Games = db.getTournamentGames(id)
+	t.Standings = tournamentStandings(t.Teams, t.Games)
+	return t, ""
+}
+
+func (db *Database) getTournamentTeams(id string) []TournamentTeam {
+	rows, err := db.dbRef.Query(`select team, player_id, coalesce(players.name, '') from tournament_players
+		left join players on players.id=player_id
+		where tournament_id=? order by tournament_players.rowid`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	teams := make([]TournamentTeam, 0)
+	for rows.Next() {
+		var team, playerId, name string
+		if err = rows.Scan(&team, &playerId, &name); err != nil {
+			log.Fatal(err)
+		}
+		if len(teams) == 0 || teams[len(teams)-1].Name != team {
+			teams = append(teams, TournamentTeam{Name: team})
+		}
+		last := &teams[len(teams)-1]
+		last.Players = append(last.Players, playerId)
+		last.Names = append(last.Names, name)
+	}
+	return teams
+}
+
+func (db *Database) getTournamentGames(id string) []TournamentGame {
+	rows, err := db.dbRef.Query(`select round, team, game_id, coalesce(games.state, 0), coalesce(games.score, 0),
+		coalesce(games.turns, 0), coalesce(games.bombs, 0)
+		from tournament_games left join games on games.id=game_id
+		where tournament_id=? order by round, tournament_games.rowid`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	games := make([]TournamentGame, 0)
+	for rows.Next() {
+		var game TournamentGame
+		if err = rows.Scan(&game.Round, &game.Team, &game.GameID, &game.State, &game.Score, &game.Turns, &game.Bombs); err != nil {
+			log.Fatal(err)
+		}
+		games = append(games, game)
+	}
+	return games
+}
+
+// tournamentStandings only counts finished games. A game ended by vote counts
+// as played, but scores nothing.
+func tournamentStandings(teams []TournamentTeam, games []TournamentGame) []TournamentStanding {
+	standings := make([]TournamentStanding, len(teams))
+	byTeam := make(map[string]*TournamentStanding)
+	for index, team := range teams {
+		standings[index].Team = team.Name
+		byTeam[team.Name] = &standings[index]
+	}
+	for _, game := range games {
+		standing, ok := byTeam[game.Team]
+		if !ok || !GameStateIsFinished(game.State) {
+			continue
+		}
+		standing.GamesPlayed++
+		standing.Turns += game.Turns
+		standing.Bombs += game.Bombs
+		if game.State != StateTerminated {
+			standing.TotalScore += game.Score
+		}
+	}
+
+	ahead := func(a TournamentStanding, b TournamentStanding) bool {
+		if a.TotalScore != b.TotalScore {
+			return a.TotalScore > b.TotalScore
+		}
+		if a.Turns != b.Turns {
+			return a.Turns < b.Turns
+		}
+		return a.Bombs < b.Bombs
+	}
+	sort.SliceStable(standings, func(i, j int) bool { return ahead(standings[i], standings[j]) })
+	for index := range standings {
+		if index > 0 && !ahead(standings[index-1], standings[index]) {
+			standings[index].Rank = standings[index-1].Rank
+		} else {
+			standings[index].Rank = index + 1
+		}
+	}
+	return standings
+}
@@ -0,0 +1,242 @@
+package lib
+
+import (
+	"log"
+	"sort"
+)
+
+// A Suit is one color of card in a variant: how many copies of each number
+// it has, and which hints touch it.
+type Suit struct {
+	Color       string
+	Counts      []int // COUNTS of each number, indexed by number (0 unused)
+	StartCards  int   // COUNT of START cards, only in up-or-down variants
+	ColorHints  int   // TouchOwn, TouchAll or TouchNone
+	NumberHints int   // TouchOwn, TouchAll or TouchNone
+}
+
+// A Variant describes everything that differs between game modes. Its ID is
+// the game's Mode, which is also what stats are keyed by.
+type Variant struct {
+	ID       int
+	Name     string
+	Suits    []Suit
+	UpOrDown bool // piles can be built from 1 up or from 5 down
+}
+
+var variants = make(map[int]*Variant)
+
+func RegisterVariant(v Variant) {
+	if _, exists := variants[v.ID]; exists || v.ID <= 0 {
+		log.Fatalf("Attempting to register variant '%s' with invalid or duplicate ID %d.", v.Name, v.ID)
+	}
+	variants[v.ID] = &v
+}
+
+// GetVariant returns nil for IDs that were never registered.
+func GetVariant(id int) *Variant {
+	return variants[id]
+}
+
+func GetVariants() []*Variant {
+	list := make([]*Variant, 0, len(variants))
+	for _, v := range variants {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func MaxVariantID() int {
+	max := 0
+	for id := range variants {
+		if id > max {
+			max = id
+		}
+	}
+	return max
+}
+
+func MaxScoreAllVariants() int {
+	max := 0
+	for _, v := range variants {
+		if v.PerfectScore() > max {
+			max = v.PerfectScore()
+		}
+	}
+	return max
+}
+
+func (v *Variant) Colors() []string {
+	colors := make([]string, len(v.Suits))
+	for index, suit := range v.Suits {
+		colors[index] = suit.Color
+	}
+	return colors
+}
+
+// HintColors are the colors a player can name when giving a color hint.
+func (v *Variant) HintColors() []string {
+	colors := make([]string, 0, len(v.Suits))
+	for _, suit := range v.Suits {
+		if suit.ColorHints == TouchOwn {
+			colors = append(colors, suit.Color)
+		}
+	}
+	return colors
+}
+
+func (v *Variant) GetSuit(color string) *Suit {
+	for index := range v.Suits {
+		if v.Suits[index].Color == color {
+			return &v.Suits[index]
+		}
+	}
+	return nil
+}
+
+// Numbers lists every number a card could have in this variant.
+func (v *Variant) Numbers() []int {
+	maxNumber, starts := 0, false
+	for _, suit := range v.Suits {
+		if v.MaxNumber(suit.Color) > maxNumber {
+			maxNumber = v.MaxNumber(suit.Color)
+		}
+		starts = starts || suit.StartCards > 0
+	}
+	numbers := make([]int, 0, maxNumber+1)
+	for number := 1; number <= maxNumber; number++ {
+		numbers = append(numbers, number)
+	}
+	if starts {
+		numbers = append(numbers, NumberStart)
+	}
+	return numbers
+}
+
+func (v *Variant) CopiesOfCard(color string, number int) int {
+	suit := v.GetSuit(color)
+	if suit != nil && number == NumberStart {
+		return suit.StartCards
+	}
+	if suit == nil || number <= 0 || number >= len(suit.Counts) {
+		return 0
+	}
+	return suit.Counts[number]
+}
+
+func (v *Variant) MaxCards() int {
+	maxCards := 0
+	for _, suit := range v.Suits {
+		maxCards += suit.StartCards
+		for _, count := range suit.Counts {
+			maxCards += count
+		}
+	}
+	return maxCards
+}
+
+// MaxNumber is the card that completes a pile of the given color.
+func (v *Variant) MaxNumber(color string) int {
+	suit := v.GetSuit(color)
+	if suit == nil {
+		return 0
+	}
+	return len(suit.Counts) - 1
+}
+
+func (v *Variant) PerfectScore() int {
+	score := 0
+	for _, suit := range v.Suits {
+		score += v.MaxNumber(suit.Color)
+	}
+	return score
+}
+
+func (v *Variant) TouchedByColor(c Card, hintColor string) bool {
+	suit := v.GetSuit(c.Color)
+	if suit == nil {
+		return false
+	}
+	switch suit.ColorHints {
+	case TouchAll:
+		return true
+	case TouchNone:
+		return false
+	}
+	return c.Color == hintColor
+}
+
+func (v *Variant) TouchedByNumber(c Card, hintNumber int) bool {
+	suit := v.GetSuit(c.Color)
+	if suit == nil {
+		return false
+	}
+	switch suit.NumberHints {
+	case TouchAll:
+		return true
+	case TouchNone:
+		return false
+	}
+	return c.Number == hintNumber
+}
+
+// HintValue works out which color and number a hint pointing at the given
+// card names. A card that isn't touched only by its own color or number can't
+// say, so the hint giver's choice is used instead.
+func (v *Variant) HintValue(c Card, hintColor string, hintNumber int) (string, int) {
+	color, number := c.Color, c.Number
+	if suit := v.GetSuit(c.Color); suit != nil {
+		if suit.ColorHints != TouchOwn {
+			color = hintColor
+		}
+		if suit.NumberHints != TouchOwn {
+			number = hintNumber
+		}
+	}
+	return color, number
+}
+
+func standardSuits(extra ...Suit) []Suit {
+	suits := make([]Suit, 0, len(normalColors)+len(extra))
+	for _, color := range normalColors {
+		suits = append(suits, Suit{Color: color, Counts: numbers[:], ColorHints: TouchOwn, NumberHints: TouchOwn})
+	}
+	return append(suits, extra...)
+}
+
+// upOrDownSuits trade two of the 1s for a START card, leaving a single 1 and
+// a single 5 so that piles are as easy to begin and finish either way.
+func upOrDownSuits() []Suit {
+	suits := make([]Suit, 0, len(normalColors))
+	for _, color := range normalColors {
+		suits = append(suits, Suit{Color: color, Counts: upOrDownNumbers[:], StartCards: 1, ColorHints: TouchOwn, NumberHints: TouchOwn})
+	}
+	return suits
+}
+
+func init() {
+	RegisterVariant(Variant{ID: ModeNormal, Name: "Normal",
+		Suits: standardSuits()})
+	RegisterVariant(Variant{ID: ModeRainbow, Name: "Rainbow",
+		Suits: standardSuits(Suit{Color: ColorRainbow, Counts: numbers[:], ColorHints: TouchOwn, NumberHints: TouchOwn})})
+	RegisterVariant(Variant{ID: ModeWildcard, Name: "Wildcard",
+		Suits: standardSuits(Suit{Color: ColorRainbow, Counts: numbers[:], ColorHints: TouchAll, NumberHints: TouchOwn})})
+	RegisterVariant(Variant{ID: ModeHard, Name: "Hard",
+		Suits: standardSuits(Suit{Color: ColorRainbow, Counts: singleNumbers[:], ColorHints: TouchAll, NumberHints: TouchOwn})})
+	RegisterVariant(Variant{ID: ModeRainbowLimited, Name: "Rainbow Limited",
+		Suits: standardSuits(Suit{Color: ColorRainbow, Counts: singleNumbers[:], ColorHints: TouchOwn, NumberHints: TouchOwn})})
+	// every black card is the only one of its kind, so all of them are critical
+	RegisterVariant(Variant{ID: ModeBlack, Name: "Black",
+		Suits: standardSuits(Suit{Color: ColorBlack, Counts: singleNumbers[:], ColorHints: TouchOwn, NumberHints: TouchOwn})})
+	// the opposite of wildcard: only number hints ever reveal a colorless card
+	RegisterVariant(Variant{ID: ModeNull, Name: "Colorless",
+		Suits: standardSuits(Suit{Color: ColorNull, Counts: numbers[:], ColorHints: TouchNone, NumberHints: TouchOwn})})
+	// and the same two ideas applied to number hints instead
+	RegisterVariant(Variant{ID: ModePink, Name: "Pink",
+		Suits: standardSuits(Suit{Color: ColorPink, Counts: numbers[:], ColorHints: TouchOwn, NumberHints: TouchAll})})
+	RegisterVariant(Variant{ID: ModeBrown, Name: "Brown",
+		Suits: standardSuits(Suit{Color: ColorBrown, Counts: numbers[:], ColorHints: TouchOwn, NumberHints: TouchNone})})
+	RegisterVariant(Variant{ID: ModeUpOrDown, Name: "Up or Down", UpOrDown: true,
+		Suits: upOrDownSuits()})
+}